@@ -3,6 +3,7 @@ package consumer
 import (
 	"context"
 	"fmt"
+	"github.com/D1sordxr/packages/log"
 	"github.com/segmentio/kafka-go"
 	"sync"
 	"time"
@@ -23,6 +24,12 @@ type Logger interface {
 	Error(msg string)
 }
 
+// errLogger is implemented by loggers writing an error with its fields and code,
+// such as *log.Log and *log.AsyncLogger.
+type errLogger interface {
+	ErrWithError(ctx context.Context, err error, msg string)
+}
+
 type Consumer struct {
 	Reader  *kafka.Reader
 	Handler Handler
@@ -63,17 +70,41 @@ func (c *Consumer) Consume(ctx context.Context, wg *sync.WaitGroup) {
 		default:
 			m, err := c.Reader.ReadMessage(ctx)
 			if err != nil {
-				c.log.Error(fmt.Sprintf("error reading message: %v", err))
+				// The reader returns the context error on shutdown, it is not a failure.
+				if ctx.Err() != nil {
+					return
+				}
+				c.logError(ctx, "error reading message",
+					log.Wrap("kafka.Reader.ReadMessage", err, nil, log.WithCode(log.CodeUnavailable)))
 				continue
 			}
 
 			if err = c.Handler.Handle(ctx, m); err != nil {
-				c.log.Error(fmt.Sprintf("error processing message: %v", err))
+				c.logError(ctx, "error processing message", err)
 			}
 		}
 	}
 }
 
+// Run consumes messages until ctx is done. It matches the function signature
+// of log.Supervisor, so a panicking handler is recovered and the consumer restarted.
+func (c *Consumer) Run(ctx context.Context) error {
+	c.Consume(ctx, nil)
+
+	return nil
+}
+
+// logError logs err with its fields and log.CodeOf as the error_code field. Loggers
+// that cannot log errors get the code in the message.
+func (c *Consumer) logError(ctx context.Context, msg string, err error) {
+	if l, ok := c.log.(errLogger); ok {
+		l.ErrWithError(ctx, err, msg)
+		return
+	}
+
+	c.log.Error(fmt.Sprintf("%s (%s=%s): %v", msg, log.ErrorCodeField, log.CodeOf(err), err))
+}
+
 func (c *Consumer) Close() {
 	if err := c.Reader.Close(); err != nil {
 		c.log.Error(fmt.Sprintf("error closing kafka reader: %v", err))
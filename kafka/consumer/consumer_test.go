@@ -0,0 +1,47 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"github.com/D1sordxr/packages/log"
+	"github.com/D1sordxr/packages/log/logtest"
+	"go.uber.org/zap/zapcore"
+	"testing"
+)
+
+type plainLogger struct {
+	errors []string
+}
+
+func (l *plainLogger) Info(string) {}
+
+func (l *plainLogger) Error(msg string) {
+	l.errors = append(l.errors, msg)
+}
+
+func TestLogErrorWritesCodeField(t *testing.T) {
+	rec := logtest.New(t)
+	c := &Consumer{log: rec}
+	ctx := log.ContextWithRequestID(context.Background(), "req-1")
+
+	err := log.Wrap("load order", log.NewError(log.CodeNotFound, "order not found"), log.Fld{"order_id": 7})
+	c.logError(ctx, "error processing message", err)
+
+	rec.AssertLogged(t, zapcore.ErrorLevel, "error processing message",
+		log.ErrorCodeField, "not_found",
+		"order_id", 7,
+		log.RequestIDField, "req-1",
+	)
+}
+
+func TestLogErrorPlainLogger(t *testing.T) {
+	l := &plainLogger{}
+	c := &Consumer{log: l}
+
+	c.logError(context.Background(), "error processing message", errors.New("boom"))
+
+	want := "error processing message (error_code=internal): boom"
+	if len(l.errors) != 1 || l.errors[0] != want {
+		t.Fatalf("errors = %q, want %q", l.errors, want)
+	}
+}
@@ -2,7 +2,9 @@ package log
 
 import (
 	"context"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,71 +15,234 @@ const (
 	DebugLogLevel  = "debug"
 	DebugfLogLevel = "debugf"
 	DebugwLogLevel = "debugw"
+	WarnLogLevel   = "warn"
+	WarnfLogLevel  = "warnf"
+	WarnwLogLevel  = "warnw"
 	ErrorLogLevel  = "error"
 	ErrorfLogLevel = "errorf"
 	ErrorwLogLevel = "errorw"
 	PanicLogLevel  = "panic"
+	GRPCLogLevel   = "grpc"
 )
 
+// AsyncMsg is a log call captured by AsyncLogger.
+// logger already carries the fields and context values of the caller.
 type AsyncMsg struct {
-	level   string
-	message string
-	args    []any
+	level     string
+	message   string
+	args      []any
+	logger    *Log
+	ctx       context.Context
+	grpcLevel logging.Level
+	flushed   chan struct{}
 }
 
-// AsyncLogger is a test-logger that processes messages asynchronously.
+const (
+	DefaultAsyncBufferSize     = 1000
+	DefaultAsyncRetryCount     = 3
+	DefaultAsyncRetryBackoff   = 10 * time.Millisecond
+	DefaultAsyncReportInterval = 10 * time.Second
+	DefaultAsyncFlushTimeout   = 5 * time.Second
+)
+
+// AsyncOptions configures an AsyncLogger.
+type AsyncOptions struct {
+	BufferSize       int
+	OverflowStrategy BufferOverflowStrategy
+	RetryCount       int
+	RetryBackoff     time.Duration
+	// ReportInterval is how often the number of dropped messages is logged.
+	ReportInterval time.Duration
+}
+
+// AsyncOption changes a single AsyncOptions setting.
+type AsyncOption func(*AsyncOptions)
+
+// WithBufferSize sets the capacity of the message buffer.
+func WithBufferSize(size int) AsyncOption {
+	return func(o *AsyncOptions) {
+		o.BufferSize = size
+	}
+}
+
+// WithOverflowStrategy sets the behaviour when the buffer is full.
+func WithOverflowStrategy(strategy BufferOverflowStrategy) AsyncOption {
+	return func(o *AsyncOptions) {
+		o.OverflowStrategy = strategy
+	}
+}
+
+// WithRetry sets how many times and how long apart the Retry strategy tries to enqueue.
+func WithRetry(count int, backoff time.Duration) AsyncOption {
+	return func(o *AsyncOptions) {
+		o.RetryCount = count
+		o.RetryBackoff = backoff
+	}
+}
+
+// WithDropReportInterval sets how often dropped messages are reported.
+func WithDropReportInterval(interval time.Duration) AsyncOption {
+	return func(o *AsyncOptions) {
+		o.ReportInterval = interval
+	}
+}
+
+func defaultAsyncOptions() AsyncOptions {
+	return AsyncOptions{
+		BufferSize:       DefaultAsyncBufferSize,
+		OverflowStrategy: Block,
+		RetryCount:       DefaultAsyncRetryCount,
+		RetryBackoff:     DefaultAsyncRetryBackoff,
+		ReportInterval:   DefaultAsyncReportInterval,
+	}
+}
+
+// asyncQueue is the buffer and worker shared by an AsyncLogger and the loggers derived from it.
+type asyncQueue struct {
+	opts       AsyncOptions
+	logChan    chan AsyncMsg
+	dropped    atomic.Uint64
+	done       chan struct{}
+	wg         sync.WaitGroup
+	processor  *Supervisor
+	mu         sync.RWMutex
+	closed     bool
+	shutdown   sync.Once
+	unregister func()
+}
+
+// AsyncLogger is a Logger that processes messages asynchronously.
+// Fields and context values are captured when a method is called,
+// the actual write happens in a background goroutine.
 type AsyncLogger struct {
-	Logger   Log
-	logChan  chan AsyncMsg
-	wg       sync.WaitGroup
-	shutdown sync.Once
+	Logger *Log
+	queue  *asyncQueue
 }
 
+var _ Logger = (*AsyncLogger)(nil)
+
 // NewAsyncLogger creates a new instance of AsyncLogger.
-func NewAsyncLogger(logger Log) *AsyncLogger {
+func NewAsyncLogger(logger *Log, opts ...AsyncOption) *AsyncLogger {
+	o := defaultAsyncOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.BufferSize < 0 {
+		o.BufferSize = 0
+	}
+
+	q := &asyncQueue{
+		opts:    o,
+		logChan: make(chan AsyncMsg, o.BufferSize),
+		done:    make(chan struct{}),
+	}
 	afl := &AsyncLogger{
 		Logger: logger,
-		//logChan: make(chan AsyncMsg, logger.Config.BufferSize),
-		logChan: make(chan AsyncMsg, 1000),
+		queue:  q,
+	}
+
+	// A panic while writing must not stop the queue, so the processor is restarted after it.
+	q.processor = logger.Go(context.Background(), "async_logger", afl.processLogs, WithRestart(RestartBackoff))
+	unregister := RegisterFlusher(afl)
+	logger.metrics.addQueue(q)
+	q.unregister = func() {
+		unregister()
+		logger.metrics.removeQueue(q)
 	}
 
-	afl.wg.Add(1)
-	go afl.processLogs()
+	if o.OverflowStrategy != Block && o.ReportInterval > 0 {
+		q.wg.Add(1)
+		go afl.reportDropped()
+	}
 
 	return afl
 }
 
-// processLogs processes log messages asynchronously from the logChan.
-func (l *AsyncLogger) processLogs() {
-	defer l.wg.Done()
+// Dropped returns the total number of messages dropped because the buffer was full.
+func (l *AsyncLogger) Dropped() uint64 {
+	return l.queue.dropped.Load()
+}
 
-	// Continuously process log messages from the channel
-	for msg := range l.logChan {
-		switch msg.level {
-		case InfoLogLevel:
-			l.Logger.Info(msg.message)
-		case InfofLogLevel:
-			l.Logger.Infof(msg.message, msg.args...)
-		case InfowLogLevel:
-			l.Logger.Infow(msg.message, msg.args...)
-		case DebugLogLevel:
-			l.Logger.Debug(msg.message)
-		case DebugfLogLevel:
-			l.Logger.Debugf(msg.message, msg.args...)
-		case DebugwLogLevel:
-			l.Logger.Debugw(msg.message, msg.args...)
-		case ErrorLogLevel:
-			l.Logger.Error(msg.message)
-		case ErrorfLogLevel:
-			l.Logger.Errorf(msg.message, msg.args...)
-		case ErrorwLogLevel:
-			l.Logger.Errorw(msg.message, msg.args...)
-		case PanicLogLevel:
-			l.Logger.Panic(msg.message)
+// reportDropped periodically logs a single summary line for dropped messages.
+func (l *AsyncLogger) reportDropped() {
+	defer l.queue.wg.Done()
+
+	ticker := time.NewTicker(l.queue.opts.ReportInterval)
+	defer ticker.Stop()
+
+	var reported uint64
+	for {
+		select {
+		case <-ticker.C:
+			reported = l.logDropped(reported)
+		case <-l.queue.done:
+			l.logDropped(reported)
+			return
 		}
 	}
 }
 
+func (l *AsyncLogger) logDropped(reported uint64) uint64 {
+	total := l.queue.dropped.Load()
+	if total > reported {
+		l.Logger.Errorf("Log buffer overflow, %d messages dropped (%d total)", total-reported, total)
+	}
+
+	return total
+}
+
+// processLogs processes log messages asynchronously from the logChan.
+// It returns when the channel is closed by Shutdown.
+func (l *AsyncLogger) processLogs(context.Context) error {
+	// Continuously process log messages from the channel
+	for msg := range l.queue.logChan {
+		l.write(msg)
+	}
+
+	return nil
+}
+
+func (l *AsyncLogger) write(msg AsyncMsg) {
+	if msg.flushed != nil {
+		close(msg.flushed)
+		return
+	}
+
+	logger := msg.logger
+	if logger == nil {
+		logger = l.Logger
+	}
+
+	switch msg.level {
+	case InfoLogLevel:
+		logger.Info(msg.message)
+	case InfofLogLevel:
+		logger.Infof(msg.message, msg.args...)
+	case InfowLogLevel:
+		logger.Infow(msg.message, msg.args...)
+	case DebugLogLevel:
+		logger.Debug(msg.message)
+	case DebugfLogLevel:
+		logger.Debugf(msg.message, msg.args...)
+	case DebugwLogLevel:
+		logger.Debugw(msg.message, msg.args...)
+	case WarnLogLevel:
+		logger.Warn(msg.message)
+	case WarnfLogLevel:
+		logger.Warnf(msg.message, msg.args...)
+	case WarnwLogLevel:
+		logger.Warnw(msg.message, msg.args...)
+	case ErrorLogLevel:
+		logger.Error(msg.message)
+	case ErrorfLogLevel:
+		logger.Errorf(msg.message, msg.args...)
+	case ErrorwLogLevel:
+		logger.Errorw(msg.message, msg.args...)
+	case GRPCLogLevel:
+		logger.LogGRPC(msg.ctx, msg.grpcLevel, msg.message, msg.args...)
+	}
+}
+
 // BufferOverflowStrategy defines how the test-logger should behave when the buffer overflows.
 type BufferOverflowStrategy int
 
@@ -91,94 +256,264 @@ const (
 )
 
 // logAsync safely sends a message to the log channel based on the overflow strategy.
+// After Shutdown messages are written synchronously.
 func (l *AsyncLogger) logAsync(msg AsyncMsg) {
+	if !l.enqueue(msg) {
+		l.write(msg)
+	}
+}
+
+// enqueue reports false when the queue is shut down and msg has to be written by the caller.
+// Waits for space also end on Shutdown, which cannot take the lock while they hold it.
+func (l *AsyncLogger) enqueue(msg AsyncMsg) bool {
+	q := l.queue
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return false
+	}
+
 	select {
-	case l.logChan <- msg:
+	case q.logChan <- msg:
 	default:
-		//switch l.Logger.Config.OverflowStrategy {
-		switch BufferOverflowStrategy(Block) {
+		switch q.opts.OverflowStrategy {
 		case Block:
 			// Block until space is available in the buffer
-			l.logChan <- msg
+			select {
+			case q.logChan <- msg:
+			case <-q.done:
+				return false
+			}
 		case Retry:
 			// Try several times before dropping the message
-			for i := 0; i < 3; i++ {
+			for i := 0; i < q.opts.RetryCount; i++ {
 				select {
-				case l.logChan <- msg:
-					return // Successfully logged the message
-				case <-time.After(10 * time.Millisecond):
+				case q.logChan <- msg:
+					return true // Successfully logged the message
+				case <-q.done:
+					return false
+				case <-time.After(q.opts.RetryBackoff):
 					// Wait before retrying
 				}
 			}
-			// Log an error if the message is dropped
-			l.Logger.Errorf("Log buffer overflow, message dropped: %s", msg.message)
-		case Drop:
+			q.dropped.Add(1)
+		default:
 			// Drop the message if the buffer is full
-			l.Logger.Errorf("Log buffer overflow, message dropped: %s", msg.message)
+			q.dropped.Add(1)
 		}
 	}
+
+	return true
+}
+
+// Flush blocks until every message queued before the call has been written,
+// or until ctx is done.
+func (l *AsyncLogger) Flush(ctx context.Context) {
+	q := l.queue
+	flushed := make(chan struct{})
+
+	q.mu.RLock()
+	if q.closed {
+		q.mu.RUnlock()
+		return
+	}
+	select {
+	case q.logChan <- AsyncMsg{flushed: flushed}:
+		q.mu.RUnlock()
+	case <-q.done:
+		q.mu.RUnlock()
+		return
+	case <-ctx.Done():
+		q.mu.RUnlock()
+		return
+	}
+
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+	}
+}
+
+func (l *AsyncLogger) derive(logger *Log) *AsyncLogger {
+	return &AsyncLogger{Logger: logger, queue: l.queue}
+}
+
+// With returns an AsyncLogger sharing the buffer and adding fld to every message.
+func (l *AsyncLogger) With(fld Fld) *AsyncLogger {
+	return l.derive(l.Logger.With(fld))
+}
+
+// Named returns an AsyncLogger writing through l.Logger.Named(name).
+func (l *AsyncLogger) Named(name string) *AsyncLogger {
+	return l.derive(l.Logger.Named(name))
+}
+
+// WithField returns an AsyncLogger with a single additional field.
+func (l *AsyncLogger) WithField(key string, val interface{}) *AsyncLogger {
+	return l.derive(l.Logger.WithField(key, val))
+}
+
+// WithErr returns an AsyncLogger carrying err and its fields.
+func (l *AsyncLogger) WithErr(err error) *AsyncLogger {
+	return l.derive(l.Logger.WithErr(err))
+}
+
+// WithCtx returns an AsyncLogger carrying the context log fields of ctx.
+func (l *AsyncLogger) WithCtx(ctx context.Context) *AsyncLogger {
+	return l.derive(l.Logger.WithCtx(ctx))
 }
 
 // Info logs a message at the Info level.
 func (l *AsyncLogger) Info(msg string) {
-	l.logAsync(AsyncMsg{level: InfoLogLevel, message: msg})
+	l.logAsync(AsyncMsg{level: InfoLogLevel, message: msg, logger: l.Logger})
 }
 
 // Infof logs a formatted message at the Info level.
 func (l *AsyncLogger) Infof(template string, args ...interface{}) {
-	l.logAsync(AsyncMsg{level: InfofLogLevel, message: template, args: args})
+	l.logAsync(AsyncMsg{level: InfofLogLevel, message: template, args: args, logger: l.Logger})
 }
 
 // Infow logs a message at the Info level with additional key-value pairs.
 func (l *AsyncLogger) Infow(msg string, args ...any) {
-	l.logAsync(AsyncMsg{level: InfowLogLevel, message: msg, args: args})
+	l.logAsync(AsyncMsg{level: InfowLogLevel, message: msg, args: args, logger: l.Logger})
 }
 
 // Debug logs a message at the Debug level.
 func (l *AsyncLogger) Debug(msg string) {
-	l.logAsync(AsyncMsg{level: DebugLogLevel, message: msg})
+	l.logAsync(AsyncMsg{level: DebugLogLevel, message: msg, logger: l.Logger})
 }
 
 // Debugf logs a formatted message at the Debug level.
 func (l *AsyncLogger) Debugf(template string, args ...interface{}) {
-	l.logAsync(AsyncMsg{level: DebugfLogLevel, message: template, args: args})
+	l.logAsync(AsyncMsg{level: DebugfLogLevel, message: template, args: args, logger: l.Logger})
 }
 
 // Debugw logs a message at the Debug level with additional key-value pairs.
 func (l *AsyncLogger) Debugw(msg string, args ...any) {
-	l.logAsync(AsyncMsg{level: DebugwLogLevel, message: msg, args: args})
+	l.logAsync(AsyncMsg{level: DebugwLogLevel, message: msg, args: args, logger: l.Logger})
+}
+
+// Warn logs a message at the Warn level.
+func (l *AsyncLogger) Warn(msg string) {
+	l.logAsync(AsyncMsg{level: WarnLogLevel, message: msg, logger: l.Logger})
+}
+
+// Warnf logs a formatted message at the Warn level.
+func (l *AsyncLogger) Warnf(template string, args ...interface{}) {
+	l.logAsync(AsyncMsg{level: WarnfLogLevel, message: template, args: args, logger: l.Logger})
+}
+
+// Warnw logs a message at the Warn level with additional key-value pairs.
+func (l *AsyncLogger) Warnw(msg string, args ...any) {
+	l.logAsync(AsyncMsg{level: WarnwLogLevel, message: msg, args: args, logger: l.Logger})
 }
 
 // Error logs a message at the Error level.
 func (l *AsyncLogger) Error(msg string) {
-	l.logAsync(AsyncMsg{level: ErrorLogLevel, message: msg})
+	l.logAsync(AsyncMsg{level: ErrorLogLevel, message: msg, logger: l.Logger})
 }
 
 // Errorf logs a formatted message at the Error level.
 func (l *AsyncLogger) Errorf(template string, args ...interface{}) {
-	l.logAsync(AsyncMsg{level: ErrorfLogLevel, message: template, args: args})
+	l.logAsync(AsyncMsg{level: ErrorfLogLevel, message: template, args: args, logger: l.Logger})
 }
 
 // Errorw logs a message at the Error level with additional key-value pairs.
 func (l *AsyncLogger) Errorw(msg string, args ...any) {
-	l.logAsync(AsyncMsg{level: ErrorwLogLevel, message: msg, args: args})
+	l.logAsync(AsyncMsg{level: ErrorwLogLevel, message: msg, args: args, logger: l.Logger})
+}
+
+// ErrWithWarn logs err with the context fields of ctx at the Warn level.
+func (l *AsyncLogger) ErrWithWarn(ctx context.Context, err error, msg string) {
+	l.WithCtx(ctx).WithErr(err).Warn(msg)
+}
+
+// ErrWithWarnf logs err with the context fields of ctx and a formatted message at the Warn level.
+func (l *AsyncLogger) ErrWithWarnf(ctx context.Context, err error, msg string, args ...interface{}) {
+	l.WithCtx(ctx).WithErr(err).Warnf(msg, args...)
+}
+
+// ErrWithWarnw logs err with the context fields of ctx and additional key-value pairs at the Warn level.
+func (l *AsyncLogger) ErrWithWarnw(ctx context.Context, err error, msg string, keysAndValues ...interface{}) {
+	l.WithCtx(ctx).WithErr(err).Warnw(msg, keysAndValues...)
+}
+
+// ErrWithError logs err with the context fields of ctx at the Error level.
+func (l *AsyncLogger) ErrWithError(ctx context.Context, err error, msg string) {
+	l.WithCtx(ctx).WithErr(err).Error(msg)
+}
+
+// ErrWithErrorf logs err with the context fields of ctx and a formatted message.
+func (l *AsyncLogger) ErrWithErrorf(ctx context.Context, err error, msg string, args ...interface{}) {
+	l.WithCtx(ctx).WithErr(err).Errorf(msg, args...)
+}
+
+// ErrWithErrorw logs err with the context fields of ctx and additional key-value pairs.
+func (l *AsyncLogger) ErrWithErrorw(ctx context.Context, err error, msg string, keysAndValues ...interface{}) {
+	l.WithCtx(ctx).WithErr(err).Errorw(msg, keysAndValues...)
 }
 
-// Panic logs a message at the Panic level.
+// LogGRPC integration for grpc unary middleware
+func (l *AsyncLogger) LogGRPC(ctx context.Context, lvl logging.Level, msg string, fields ...any) {
+	l.logAsync(AsyncMsg{level: GRPCLogLevel, message: msg, args: fields, logger: l.Logger, ctx: ctx, grpcLevel: lvl})
+}
+
+// Panic flushes the buffer and panics in the calling goroutine.
 func (l *AsyncLogger) Panic(msg string) {
-	l.logAsync(AsyncMsg{level: PanicLogLevel, message: msg})
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultAsyncFlushTimeout)
+	defer cancel()
+
+	l.Flush(ctx)
+	l.Logger.Panic(msg)
+}
+
+// Fatal writes the buffered messages, logs msg, flushes all sinks and exits the process.
+func (l *AsyncLogger) Fatal(msg string) {
+	l.flushBeforeFatal()
+	l.Logger.Fatal(msg)
+}
+
+// Fatalf writes the buffered messages, logs a formatted message, flushes all sinks and exits the process.
+func (l *AsyncLogger) Fatalf(template string, args ...interface{}) {
+	l.flushBeforeFatal()
+	l.Logger.Fatalf(template, args...)
+}
+
+// Fatalw writes the buffered messages, logs msg with key-value pairs, flushes all sinks and exits the process.
+func (l *AsyncLogger) Fatalw(msg string, args ...any) {
+	l.flushBeforeFatal()
+	l.Logger.Fatalw(msg, args...)
+}
+
+// flushBeforeFatal writes the buffered messages so they precede the fatal entry.
+func (l *AsyncLogger) flushBeforeFatal() {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultAsyncFlushTimeout)
+	defer cancel()
+
+	l.Flush(ctx)
 }
 
 // Shutdown waits for all log messages to be processed and gracefully shuts down the test-logger.
 func (l *AsyncLogger) Shutdown(ctx context.Context) {
-	l.shutdown.Do(func() {
+	q := l.queue
+	q.shutdown.Do(func() {
+		q.unregister()
+
+		// Wake up callers waiting for space first, they hold the lock taken below
+		close(q.done)
+
 		// Close the log channel to stop accepting new messages
-		close(l.logChan)
+		q.mu.Lock()
+		q.closed = true
+		close(q.logChan)
+		q.mu.Unlock()
 
 		done := make(chan struct{})
 		// Wait for all logs to be processed
 		go func() {
-			l.wg.Wait()
+			q.wg.Wait()
+			_ = q.processor.Wait()
 			close(done)
 		}()
 
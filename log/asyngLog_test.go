@@ -0,0 +1,172 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsyncLoggerShutdownHonoursDeadline(t *testing.T) {
+	for _, strategy := range []BufferOverflowStrategy{Block, Retry} {
+		core := &blockingCore{LevelEnabler: zapcore.DebugLevel, release: make(chan struct{}), writing: make(chan struct{}, 1)}
+		l := NewWithCore(Config{LogLevel: "info"}, core)
+		async := l.ToAsync(WithBufferSize(1), WithOverflowStrategy(strategy), WithRetry(1000, 10*time.Millisecond))
+
+		async.Info("stuck in the processor")
+		<-core.writing
+		async.Info("fills the buffer")
+
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			async.Info("waits for space")
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		async.Shutdown(ctx)
+		cancel()
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("strategy %d: Shutdown took %v, want it to return at the deadline", strategy, elapsed)
+		}
+
+		close(core.release)
+		select {
+		case <-sent:
+		case <-time.After(time.Second):
+			t.Fatalf("strategy %d: waiting caller was not released", strategy)
+		}
+	}
+}
+
+func TestAsyncLoggerWritesAfterShutdown(t *testing.T) {
+	l, buf := newBufferLog(t, Config{})
+	async := l.ToAsync()
+
+	async.Info("queued")
+	async.Shutdown(context.Background())
+	async.Info("after shutdown")
+
+	for _, msg := range []string{"queued", "after shutdown"} {
+		if !bytes.Contains(buf.Bytes(), []byte(msg)) {
+			t.Errorf("%q was not written: %s", msg, buf)
+		}
+	}
+}
+
+// gateCore holds the entry with message "stuck" until release is closed.
+type gateCore struct {
+	zapcore.Core
+	release chan struct{}
+	writing chan struct{}
+}
+
+func (c *gateCore) With(fields []zapcore.Field) zapcore.Core {
+	return &gateCore{Core: c.Core.With(fields), release: c.release, writing: c.writing}
+}
+
+func (c *gateCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
+func (c *gateCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Message == "stuck" {
+		c.writing <- struct{}{}
+		<-c.release
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+func TestAsyncLoggerReportsDropped(t *testing.T) {
+	for _, strategy := range []BufferOverflowStrategy{Drop, Retry} {
+		buf := &syncBuffer{}
+		core := &gateCore{
+			Core:    zapcore.NewCore(zapcore.NewJSONEncoder(defaultEncoderConfig()), zapcore.AddSync(buf), zapcore.DebugLevel),
+			release: make(chan struct{}),
+			writing: make(chan struct{}),
+		}
+		l := NewWithCore(Config{LogLevel: "info"}, core)
+		async := l.ToAsync(WithBufferSize(1), WithOverflowStrategy(strategy), WithRetry(2, time.Millisecond),
+			WithDropReportInterval(5*time.Millisecond))
+
+		// The processor is stuck on the first message and the second fills the buffer.
+		async.Info("stuck")
+		<-core.writing
+		async.Info("queued")
+
+		// A summary is logged every interval with drops, a slow Retry may
+		// spread a batch of drops over several of them.
+		waitReport := func(total int) {
+			t.Helper()
+			deadline := time.Now().Add(time.Second)
+			for {
+				errs := messages(t, buf, "ERROR")
+				if len(errs) > 0 && strings.HasSuffix(errs[len(errs)-1], fmt.Sprintf(" dropped (%d total)", total)) {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("strategy %d: reports = %q, want %d total last", strategy, errs, total)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			async.Info("dropped")
+		}
+		waitReport(3)
+		for i := 0; i < 2; i++ {
+			async.Info("dropped")
+		}
+		waitReport(5)
+
+		close(core.release)
+		async.Shutdown(context.Background())
+
+		// Every drop is reported once, nothing more at shutdown.
+		var reported, total int
+		for _, msg := range messages(t, buf, "ERROR") {
+			var n int
+			if _, err := fmt.Sscanf(msg, "Log buffer overflow, %d messages dropped (%d total)", &n, &total); err != nil || n == 0 {
+				t.Errorf("strategy %d: report %q", strategy, msg)
+			}
+			reported += n
+		}
+		if reported != 5 || total != 5 {
+			t.Errorf("strategy %d: reported %d drops, %d total, want 5", strategy, reported, total)
+		}
+		if async.Dropped() != 5 {
+			t.Errorf("strategy %d: Dropped = %d, want 5", strategy, async.Dropped())
+		}
+		if infos := messages(t, buf, "INFO"); strings.Join(infos, ",") != "stuck,queued" {
+			t.Errorf("strategy %d: written %q", strategy, infos)
+		}
+	}
+}
+
+func TestAsyncLoggerReportsDroppedAtShutdown(t *testing.T) {
+	buf := &syncBuffer{}
+	core := &gateCore{
+		Core:    zapcore.NewCore(zapcore.NewJSONEncoder(defaultEncoderConfig()), zapcore.AddSync(buf), zapcore.DebugLevel),
+		release: make(chan struct{}),
+		writing: make(chan struct{}),
+	}
+	l := NewWithCore(Config{LogLevel: "info"}, core)
+	async := l.ToAsync(WithBufferSize(1), WithOverflowStrategy(Drop), WithDropReportInterval(time.Hour))
+
+	async.Info("stuck")
+	<-core.writing
+	async.Info("queued")
+	async.Info("dropped")
+	close(core.release)
+	async.Shutdown(context.Background())
+
+	if errs := messages(t, buf, "ERROR"); len(errs) != 1 || errs[0] != "Log buffer overflow, 1 messages dropped (1 total)" {
+		t.Errorf("reports = %q, want the final summary", errs)
+	}
+}
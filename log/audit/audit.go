@@ -0,0 +1,261 @@
+// Package audit writes a tamper-evident audit trail, separate from diagnostic logs.
+// Every record carries the hash of the previous one, so modifying, removing or
+// reordering records breaks the chain and is reported by Verify. Records are
+// stored in an append-only file (FileStore) or a Postgres table (PostgresStore).
+//
+// A plain hash chain only proves that the records are consistent with each
+// other: whoever can write to the store can rewrite it and compute a new chain.
+// Either sign the chain with a key kept outside the store (WithHMACKey and
+// VerifyKey), or keep the last record returned by Verify somewhere else and
+// compare it on the next run.
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/D1sordxr/packages/log"
+	"sync"
+	"time"
+)
+
+// Outcome is the result of an audited action.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+	OutcomeDenied  Outcome = "denied"
+)
+
+var (
+	// ErrGap is reported when a sequence number is missing or repeated.
+	ErrGap = errors.New("audit: gap in record sequence")
+	// ErrBrokenChain is reported when a record does not reference the hash of the previous one.
+	ErrBrokenChain = errors.New("audit: broken hash chain")
+	// ErrModified is reported when the content of a record does not match its hash.
+	ErrModified = errors.New("audit: record modified")
+	// ErrMalformed is reported when a stored record cannot be decoded.
+	ErrMalformed = errors.New("audit: malformed record")
+)
+
+// AuditEvent describes who did what to which resource and how it ended.
+type AuditEvent struct {
+	Actor    string            `json:"actor"`
+	Action   string            `json:"action"`
+	Resource string            `json:"resource"`
+	Outcome  Outcome           `json:"outcome"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// Record is a stored AuditEvent with its position in the chain.
+// Time is kept in UTC with microsecond precision so it survives a Postgres round trip.
+type Record struct {
+	Seq       uint64            `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Event     AuditEvent        `json:"event"`
+	RequestID string            `json:"request_id,omitempty"`
+	Context   map[string]string `json:"context,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash,omitempty"`
+}
+
+// ComputeHash returns the hash of r chained to r.PrevHash, ignoring r.Hash.
+func (r Record) ComputeHash() (string, error) {
+	return r.ComputeHMAC(nil)
+}
+
+// ComputeHMAC is ComputeHash keyed with an HMAC-SHA256 key, a nil key gives a plain SHA-256.
+func (r Record) ComputeHMAC(key []byte) (string, error) {
+	r.Hash = ""
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.New()
+	if key != nil {
+		sum = hmac.New(sha256.New, key)
+	}
+	sum.Write([]byte(r.PrevHash))
+	sum.Write([]byte{'\n'})
+	sum.Write(payload)
+
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// Store persists records in order.
+type Store interface {
+	// Last returns the last record, nil when the trail is empty.
+	Last(ctx context.Context) (*Record, error)
+	// Append stores rec after the last record.
+	Append(ctx context.Context, rec *Record) error
+	// Records calls fn for every record in sequence order.
+	Records(ctx context.Context, fn func(rec Record) error) error
+}
+
+// Locker is implemented by stores shared between processes. Log reads the last
+// record and appends the next one inside WithAppendLock, so no other writer
+// can append in between. fn must use the context it is given.
+type Locker interface {
+	WithAppendLock(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Logger appends events to a Store.
+type Logger struct {
+	store       Store
+	contextKeys []string
+	key         []byte
+	now         func() time.Time
+
+	mu sync.Mutex
+}
+
+// Option configures a Logger.
+type Option func(*Logger)
+
+// WithContextFields records the context values of keys with every event,
+// usually log.Config.ContextLogFields.
+func WithContextFields(keys []string) Option {
+	return func(l *Logger) {
+		l.contextKeys = keys
+	}
+}
+
+// WithHMACKey signs the chain with an HMAC-SHA256 key. Without the key a
+// rewritten chain cannot be told apart from the original, so the key must not
+// be readable by whoever can write to the store. Check the trail with
+// Logger.Verify or VerifyKey.
+func WithHMACKey(key []byte) Option {
+	return func(l *Logger) {
+		l.key = key
+	}
+}
+
+// New returns a Logger writing to store.
+func New(store Store, opts ...Option) *Logger {
+	l := &Logger{store: store, now: time.Now}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Log appends ev to the trail. The request id and the configured context
+// fields are taken from ctx. Appends are serialized within the process and,
+// when the store implements Locker, between processes.
+func (l *Logger) Log(ctx context.Context, ev AuditEvent) error {
+	const op = "audit.Logger.Log"
+
+	rec := Record{
+		Time:    l.now().UTC().Truncate(time.Microsecond),
+		Event:   ev,
+		Context: l.contextValues(ctx),
+	}
+	if id, ok := log.RequestIDFromContext(ctx); ok {
+		rec.RequestID = id
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err error
+	if locker, ok := l.store.(Locker); ok {
+		err = locker.WithAppendLock(ctx, func(ctx context.Context) error {
+			return l.append(ctx, &rec)
+		})
+	} else {
+		err = l.append(ctx, &rec)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (l *Logger) append(ctx context.Context, rec *Record) error {
+	last, err := l.store.Last(ctx)
+	if err != nil {
+		return err
+	}
+	rec.Seq, rec.PrevHash = 1, ""
+	if last != nil {
+		rec.Seq = last.Seq + 1
+		rec.PrevHash = last.Hash
+	}
+	if rec.Hash, err = rec.ComputeHMAC(l.key); err != nil {
+		return err
+	}
+
+	return l.store.Append(ctx, rec)
+}
+
+// Verify checks the trail of l with its key, see VerifyKey.
+func (l *Logger) Verify(ctx context.Context) (*Record, error) {
+	return VerifyKey(ctx, l.store, l.key)
+}
+
+func (l *Logger) contextValues(ctx context.Context) map[string]string {
+	var values map[string]string
+	for _, key := range l.contextKeys {
+		if key == log.RequestIDField {
+			continue
+		}
+		if v := ctx.Value(key); v != nil {
+			if values == nil {
+				values = make(map[string]string, len(l.contextKeys))
+			}
+			values[key] = fmt.Sprint(v)
+		}
+	}
+
+	return values
+}
+
+// Verify walks the trail and checks sequence numbers, the hash chain and the
+// hash of every record. It returns the last record, which should be kept
+// outside the store to detect truncation of the tail or, for a trail written
+// without a key, a rewritten chain. Errors wrap ErrGap, ErrBrokenChain,
+// ErrModified or ErrMalformed.
+func Verify(ctx context.Context, store Store) (*Record, error) {
+	return VerifyKey(ctx, store, nil)
+}
+
+// VerifyKey is Verify for a trail written with WithHMACKey(key).
+func VerifyKey(ctx context.Context, store Store, key []byte) (*Record, error) {
+	var last *Record
+	err := store.Records(ctx, func(rec Record) error {
+		expectedSeq, prevHash := uint64(1), ""
+		if last != nil {
+			expectedSeq, prevHash = last.Seq+1, last.Hash
+		}
+
+		switch {
+		case rec.Seq != expectedSeq:
+			return fmt.Errorf("record %d, expected %d: %w", rec.Seq, expectedSeq, ErrGap)
+		case rec.PrevHash != prevHash:
+			return fmt.Errorf("record %d: %w", rec.Seq, ErrBrokenChain)
+		}
+		hash, err := rec.ComputeHMAC(key)
+		if err != nil {
+			return fmt.Errorf("record %d: %w: %w", rec.Seq, ErrMalformed, err)
+		}
+		if !hmac.Equal([]byte(hash), []byte(rec.Hash)) {
+			return fmt.Errorf("record %d: %w", rec.Seq, ErrModified)
+		}
+
+		last = &rec
+		return nil
+	})
+	if err != nil {
+		return last, fmt.Errorf("audit.Verify: %w", err)
+	}
+
+	return last, nil
+}
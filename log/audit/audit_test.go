@@ -0,0 +1,255 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/D1sordxr/packages/log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKey = []byte("audit-test-key")
+
+func newFileLogger(t *testing.T, opts ...Option) (*Logger, *FileStore) {
+	t.Helper()
+
+	store, err := OpenFile(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	l := New(store, opts...)
+	ctx := log.ContextWithRequestID(context.Background(), "req-1")
+	for _, action := range []string{"login", "update", "logout"} {
+		if err = l.Log(ctx, AuditEvent{Actor: "alice", Action: action, Resource: "account", Outcome: OutcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return l, store
+}
+
+// editRecords rewrites the audit file, applying edit to the decoded records.
+func editRecords(t *testing.T, store *FileStore, edit func([]Record) []Record) {
+	t.Helper()
+
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var rec Record
+		if err = json.Unmarshal(line, &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	var out bytes.Buffer
+	for _, rec := range edit(records) {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Write(append(line, '\n'))
+	}
+	if err = os.WriteFile(store.path, out.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rechain recomputes the hashes of records the way a writer without the key would.
+func rechain(t *testing.T, records []Record, key []byte) {
+	t.Helper()
+
+	prev := ""
+	for i := range records {
+		records[i].PrevHash = prev
+		hash, err := records[i].ComputeHMAC(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records[i].Hash = hash
+		prev = hash
+	}
+}
+
+func TestFileStoreChain(t *testing.T) {
+	l, store := newFileLogger(t)
+
+	last, err := Verify(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Seq != 3 || last.Event.Action != "logout" || last.RequestID != "req-1" {
+		t.Errorf("last = %+v", last)
+	}
+
+	// The chain continues after reopening the file.
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l.store, err = OpenFile(store.path); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Log(context.Background(), AuditEvent{Actor: "bob", Action: "login", Outcome: OutcomeDenied}); err != nil {
+		t.Fatal(err)
+	}
+	if last, err = l.Verify(context.Background()); err != nil || last.Seq != 4 {
+		t.Errorf("Verify = %+v, %v", last, err)
+	}
+}
+
+func TestFileStoreTampering(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func([]Record) []Record
+		want error
+	}{
+		{
+			name: "modified",
+			edit: func(records []Record) []Record {
+				records[1].Event.Outcome = OutcomeFailure
+				return records
+			},
+			want: ErrModified,
+		},
+		{
+			name: "removed",
+			edit: func(records []Record) []Record {
+				return append(records[:1], records[2:]...)
+			},
+			want: ErrGap,
+		},
+		{
+			name: "reordered",
+			edit: func(records []Record) []Record {
+				records[1].Seq, records[2].Seq = records[2].Seq, records[1].Seq
+				records[1], records[2] = records[2], records[1]
+				return records
+			},
+			want: ErrBrokenChain,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range [][]byte{nil, testKey} {
+				_, store := newFileLogger(t, WithHMACKey(key))
+				editRecords(t, store, tt.edit)
+
+				last, err := VerifyKey(context.Background(), store, key)
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("key %q: Verify = %v, want %v", key, err, tt.want)
+				}
+				if last == nil || last.Seq != 1 {
+					t.Errorf("key %q: last verified = %+v, want record 1", key, last)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStoreRewrittenChain(t *testing.T) {
+	forge := func(t *testing.T, store *FileStore) {
+		editRecords(t, store, func(records []Record) []Record {
+			records[1].Event.Outcome = OutcomeFailure
+			rechain(t, records, nil)
+			return records
+		})
+	}
+
+	// Without a key the forged chain is consistent, only an anchored last record reveals it.
+	_, store := newFileLogger(t)
+	anchor, err := Verify(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forge(t, store)
+	last, err := Verify(context.Background(), store)
+	if err != nil {
+		t.Fatalf("rewritten chain without a key: %v", err)
+	}
+	if last.Hash == anchor.Hash {
+		t.Error("anchored hash matches the rewritten chain")
+	}
+
+	l, store := newFileLogger(t, WithHMACKey(testKey))
+	forge(t, store)
+	if _, err = l.Verify(context.Background()); !errors.Is(err, ErrModified) {
+		t.Errorf("rewritten chain with a key: Verify = %v, want %v", err, ErrModified)
+	}
+	if _, err = VerifyKey(context.Background(), store, []byte("other-key")); !errors.Is(err, ErrModified) {
+		t.Errorf("wrong key: Verify = %v, want %v", err, ErrModified)
+	}
+}
+
+func TestFileStoreMalformed(t *testing.T) {
+	_, store := newFileLogger(t)
+
+	file, err := os.OpenFile(store.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString("{not json\n")
+	_ = file.Close()
+
+	if _, err = Verify(context.Background(), store); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Verify = %v, want %v", err, ErrMalformed)
+	}
+}
+
+// lockingStore records the calls made inside WithAppendLock.
+type lockingStore struct {
+	*FileStore
+	locked bool
+	calls  []string
+}
+
+type lockedKey struct{}
+
+func (s *lockingStore) WithAppendLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	s.locked = true
+	defer func() { s.locked = false }()
+
+	return fn(context.WithValue(ctx, lockedKey{}, true))
+}
+
+func (s *lockingStore) Last(ctx context.Context) (*Record, error) {
+	s.record(ctx, "last")
+	return s.FileStore.Last(ctx)
+}
+
+func (s *lockingStore) Append(ctx context.Context, rec *Record) error {
+	s.record(ctx, "append")
+	return s.FileStore.Append(ctx, rec)
+}
+
+func (s *lockingStore) record(ctx context.Context, call string) {
+	if s.locked && ctx.Value(lockedKey{}) == true {
+		call += " locked"
+	}
+	s.calls = append(s.calls, call)
+}
+
+func TestLogUsesAppendLock(t *testing.T) {
+	file, err := OpenFile(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	store := &lockingStore{FileStore: file}
+	if err = New(store).Log(context.Background(), AuditEvent{Actor: "alice", Action: "login"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.calls) != 2 || store.calls[0] != "last locked" || store.calls[1] != "append locked" {
+		t.Errorf("calls = %q, want last and append under the lock", store.calls)
+	}
+}
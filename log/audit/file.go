@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// maxRecordSize bounds a single line of the audit file.
+const maxRecordSize = 1024 * 1024
+
+// FileStore keeps records as JSON lines in a file opened in append-only mode.
+// Every append is synced to disk before Log returns.
+type FileStore struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	last *Record
+}
+
+var _ Store = (*FileStore)(nil)
+
+// OpenFile opens or creates the audit file at path and reads its last record.
+func OpenFile(path string) (*FileStore, error) {
+	const op = "audit.OpenFile"
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s := &FileStore{path: path, file: file}
+	err = s.Records(context.Background(), func(rec Record) error {
+		s.last = &rec
+		return nil
+	})
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Last(context.Context) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last == nil {
+		return nil, nil
+	}
+	last := *s.last
+
+	return &last, nil
+}
+
+func (s *FileStore) Append(_ context.Context, rec *Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = s.file.Sync(); err != nil {
+		return err
+	}
+	stored := *rec
+	s.last = &stored
+
+	return nil
+}
+
+// Records reads the file from the start, a line that cannot be decoded is reported as ErrMalformed.
+func (s *FileStore) Records(ctx context.Context, fn func(rec Record) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		var rec Record
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w: %w", line, ErrMalformed, err)
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Close closes the audit file.
+func (s *FileStore) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/D1sordxr/packages/postgres/executor"
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultTable is the table used by PostgresStore when none is given.
+const DefaultTable = "audit_log"
+
+// PostgresStore keeps records in a table through executor.Manager. When ctx
+// carries a transaction the record is written in it, so it is committed or
+// rolled back together with the audited change. Batches are not used, the
+// last record has to be read before appending.
+//
+// Appends take a transaction-level advisory lock on the table, so writers in
+// other transactions and processes wait until the transaction holding it
+// commits or rolls back. Keep transactions that write audit records short.
+type PostgresStore struct {
+	manager *executor.Manager
+	table   string
+}
+
+var (
+	_ Store  = (*PostgresStore)(nil)
+	_ Locker = (*PostgresStore)(nil)
+)
+
+// NewPostgresStore returns a store writing to table, DefaultTable when empty.
+func NewPostgresStore(manager *executor.Manager, table string) *PostgresStore {
+	if table == "" {
+		table = DefaultTable
+	}
+
+	return &PostgresStore{manager: manager, table: pgx.Identifier{table}.Sanitize()}
+}
+
+// CreateTable creates the audit table if it does not exist.
+// The primary key on seq rejects appends that bypass WithAppendLock.
+func (s *PostgresStore) CreateTable(ctx context.Context) error {
+	const op = "audit.PostgresStore.CreateTable"
+
+	_, err := s.executor(ctx).Exec(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		seq        BIGINT PRIMARY KEY,
+		time       TIMESTAMPTZ NOT NULL,
+		actor      TEXT NOT NULL,
+		action     TEXT NOT NULL,
+		resource   TEXT NOT NULL,
+		outcome    TEXT NOT NULL,
+		details    JSONB,
+		request_id TEXT NOT NULL DEFAULT '',
+		context    JSONB,
+		prev_hash  TEXT NOT NULL,
+		hash       TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// WithAppendLock runs fn holding pg_advisory_xact_lock for the table. The lock
+// is taken in the transaction from ctx and held until it ends; without one fn
+// runs in a new transaction that is committed when fn succeeds.
+func (s *PostgresStore) WithAppendLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	const op = "audit.PostgresStore.WithAppendLock"
+
+	if tx, ok := s.manager.ExtractTx(ctx); ok {
+		if err := s.lock(ctx, tx); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return fn(ctx)
+	}
+
+	tx, err := s.manager.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err = s.lock(ctx, tx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err = fn(s.manager.InjectTx(ctx, tx)); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) lock(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, s.table)
+	return err
+}
+
+func (s *PostgresStore) Last(ctx context.Context) (*Record, error) {
+	const op = "audit.PostgresStore.Last"
+
+	rec, err := scanRecord(s.executor(ctx).QueryRow(ctx, s.selectSQL()+` ORDER BY seq DESC LIMIT 1`))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rec, nil
+}
+
+func (s *PostgresStore) Append(ctx context.Context, rec *Record) error {
+	const op = "audit.PostgresStore.Append"
+
+	_, err := s.executor(ctx).Exec(ctx, `INSERT INTO `+s.table+`
+		(seq, time, actor, action, resource, outcome, details, request_id, context, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		int64(rec.Seq), rec.Time, rec.Event.Actor, rec.Event.Action, rec.Event.Resource,
+		string(rec.Event.Outcome), rec.Event.Details, rec.RequestID, rec.Context, rec.PrevHash, rec.Hash,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) Records(ctx context.Context, fn func(rec Record) error) error {
+	const op = "audit.PostgresStore.Records"
+
+	rows, err := s.executor(ctx).Query(ctx, s.selectSQL()+` ORDER BY seq`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return fmt.Errorf("%s: %w: %w", op, ErrMalformed, err)
+		}
+		if err = fn(*rec); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// executor returns the transaction from ctx or the pool, never a batch.
+func (s *PostgresStore) executor(ctx context.Context) executor.Executor {
+	if tx, ok := s.manager.ExtractTx(ctx); ok {
+		return tx
+	}
+
+	return s.manager.GetPoolExecutor()
+}
+
+func (s *PostgresStore) selectSQL() string {
+	return `SELECT seq, time, actor, action, resource, outcome, details, request_id, context, prev_hash, hash FROM ` + s.table
+}
+
+func scanRecord(row pgx.Row) (*Record, error) {
+	var (
+		rec     Record
+		seq     int64
+		outcome string
+	)
+	err := row.Scan(&seq, &rec.Time, &rec.Event.Actor, &rec.Event.Action, &rec.Event.Resource,
+		&outcome, &rec.Event.Details, &rec.RequestID, &rec.Context, &rec.PrevHash, &rec.Hash)
+	if err != nil {
+		return nil, err
+	}
+	rec.Seq = uint64(seq)
+	rec.Time = rec.Time.UTC()
+	rec.Event.Outcome = Outcome(outcome)
+
+	return &rec, nil
+}
@@ -0,0 +1,98 @@
+package log
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrorCodeField is the field WithErr uses for the error code.
+const ErrorCodeField = "error_code"
+
+// Code classifies errors independently of the transport.
+// grpclog and httplog map codes to gRPC and HTTP statuses.
+type Code string
+
+const (
+	CodeNotFound         Code = "not_found"
+	CodeConflict         Code = "conflict"
+	CodeInvalid          Code = "invalid"
+	CodeUnavailable      Code = "unavailable"
+	CodeCanceled         Code = "canceled"
+	CodeDeadlineExceeded Code = "deadline_exceeded"
+	CodeInternal         Code = "internal"
+)
+
+type coder interface {
+	Code() Code
+}
+
+// WrapOption configures the error returned by Wrap.
+type WrapOption func(*FieldsError)
+
+// WithCode sets the code of the wrapped error.
+func WithCode(code Code) WrapOption {
+	return func(e *FieldsError) {
+		e.code = code
+	}
+}
+
+// codeError is a sentinel error with a code.
+type codeError struct {
+	code Code
+	msg  string
+}
+
+// NewError returns a sentinel error classified with code, for use with errors.Is.
+func NewError(code Code, msg string) error {
+	return &codeError{code: code, msg: msg}
+}
+
+func (e *codeError) Error() string {
+	return e.msg
+}
+
+func (e *codeError) Code() Code {
+	return e.code
+}
+
+// CodeOf returns the outermost code in the chain of err, including errors.Join trees.
+// Context errors are reported as CodeCanceled and CodeDeadlineExceeded, so they
+// are not retried like CodeUnavailable, and unclassified errors as CodeInternal.
+// It returns an empty code for a nil error.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	if code := findCode(err); code != "" {
+		return code
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadlineExceeded
+	}
+
+	return CodeInternal
+}
+
+func findCode(err error) Code {
+	if c, ok := err.(coder); ok && c.Code() != "" {
+		return c.Code()
+	}
+
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			if code := findCode(inner); code != "" {
+				return code
+			}
+		}
+	case interface{ Unwrap() error }:
+		if inner := e.Unwrap(); inner != nil {
+			return findCode(inner)
+		}
+	}
+
+	return ""
+}
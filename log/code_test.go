@@ -0,0 +1,35 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCodeOf(t *testing.T) {
+	notFound := NewError(CodeNotFound, "order not found")
+
+	tests := []struct {
+		name string
+		err  error
+		want Code
+	}{
+		{name: "nil", err: nil, want: ""},
+		{name: "unclassified", err: errors.New("boom"), want: CodeInternal},
+		{name: "sentinel", err: notFound, want: CodeNotFound},
+		{name: "wrapped", err: fmt.Errorf("load: %w", notFound), want: CodeNotFound},
+		{name: "outermost", err: Wrap("load", notFound, nil, WithCode(CodeUnavailable)), want: CodeUnavailable},
+		{name: "joined", err: errors.Join(errors.New("boom"), notFound), want: CodeNotFound},
+		{name: "canceled", err: fmt.Errorf("query: %w", context.Canceled), want: CodeCanceled},
+		{name: "deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), want: CodeDeadlineExceeded},
+		{name: "classified deadline", err: Wrap("query", context.DeadlineExceeded, nil, WithCode(CodeUnavailable)), want: CodeUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CodeOf(tt.err); got != tt.want {
+				t.Errorf("CodeOf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package log
+
+import "context"
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	debugKey
+	fieldsKey
+)
+
+// ContextWithRequestID returns a copy of ctx carrying the request id logged as RequestIDField.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext returns the request id set by ContextWithRequestID
+// or, for compatibility, stored under the RequestIDField string key.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	if id, ok := ctx.Value(requestIDKey).(string); ok {
+		return id, true
+	}
+	id, ok := ctx.Value(RequestIDField).(string)
+
+	return id, ok
+}
+
+// ContextWithDebug returns a copy of ctx for which debug logs are written regardless of the level.
+func ContextWithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, debugKey, true)
+}
+
+// DebugFromContext reports whether debug logs were enabled for ctx.
+func DebugFromContext(ctx context.Context) bool {
+	if on, ok := ctx.Value(debugKey).(bool); ok {
+		return on
+	}
+
+	return ctx.Value(DebugField) != nil
+}
+
+// ContextWithFields returns a copy of ctx carrying fld in addition to the fields
+// of the parent contexts. Later values override earlier ones.
+func ContextWithFields(ctx context.Context, fld Fld) context.Context {
+	if len(fld) == 0 {
+		return ctx
+	}
+
+	parent := FieldsFromContext(ctx)
+	merged := make(Fld, len(parent)+len(fld))
+	for k, v := range parent {
+		merged[k] = v
+	}
+	for k, v := range fld {
+		merged[k] = v
+	}
+
+	return context.WithValue(ctx, fieldsKey, merged)
+}
+
+// FieldsFromContext returns the fields accumulated by ContextWithFields.
+// The result must not be modified.
+func FieldsFromContext(ctx context.Context) Fld {
+	fld, _ := ctx.Value(fieldsKey).(Fld)
+	return fld
+}
+
+// contextFields collects every field WithCtx adds for ctx.
+func (l *Log) contextFields(ctx context.Context) Fld {
+	fields := Fld{}
+	for k, v := range FieldsFromContext(ctx) {
+		fields[k] = v
+	}
+	for _, key := range l.Config.ContextLogFields {
+		v := ctx.Value(key)
+		if v != nil {
+			fields[key] = v
+		}
+	}
+	if id, ok := ctx.Value(requestIDKey).(string); ok {
+		fields[RequestIDField] = id
+	}
+	for k, v := range traceFields(ctx) {
+		fields[k] = v
+	}
+
+	return fields
+}
@@ -0,0 +1,63 @@
+package log
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextWithFields(t *testing.T) {
+	parent := ContextWithFields(context.Background(), Fld{"tenant": "acme", "user_id": 1})
+	child := ContextWithFields(parent, Fld{"user_id": 2, "order_id": 7})
+
+	if got := FieldsFromContext(parent); len(got) != 2 || got["user_id"] != 1 {
+		t.Errorf("parent fields = %v, the child changed them", got)
+	}
+	got := FieldsFromContext(child)
+	if len(got) != 3 || got["tenant"] != "acme" || got["user_id"] != 2 || got["order_id"] != 7 {
+		t.Errorf("child fields = %v", got)
+	}
+	if ContextWithFields(child, nil) != child {
+		t.Error("no fields should return ctx itself")
+	}
+	if got := FieldsFromContext(context.Background()); got != nil {
+		t.Errorf("fields of an empty context = %v", got)
+	}
+}
+
+func TestContextFieldsPrecedence(t *testing.T) {
+	l, buf := newBufferLog(t, Config{ContextLogFields: []string{"tenant"}})
+
+	ctx := ContextWithFields(context.Background(), Fld{"tenant": "from fields", RequestIDField: "from fields", "user_id": 1})
+	ctx = context.WithValue(ctx, "tenant", "from string key")
+	ctx = context.WithValue(ctx, RequestIDField, "from string key")
+	l.WithCtx(ctx).Info("legacy keys")
+	l.WithCtx(ContextWithRequestID(ctx, "typed")).Info("typed key")
+
+	got := entries(t, buf)
+	// Legacy string keys override ContextWithFields, the typed request id overrides both.
+	if got[0]["tenant"] != "from string key" || got[0][RequestIDField] != "from string key" || got[0]["user_id"] != float64(1) {
+		t.Errorf("entry = %v", got[0])
+	}
+	if got[1][RequestIDField] != "typed" || got[1]["tenant"] != "from string key" {
+		t.Errorf("entry = %v", got[1])
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	if id, ok := RequestIDFromContext(context.Background()); ok || id != "" {
+		t.Errorf("RequestIDFromContext = %q, %v for an empty context", id, ok)
+	}
+
+	legacy := context.WithValue(context.Background(), RequestIDField, "legacy")
+	if id, ok := RequestIDFromContext(legacy); !ok || id != "legacy" {
+		t.Errorf("RequestIDFromContext = %q, %v, want the string key fallback", id, ok)
+	}
+	if id, ok := RequestIDFromContext(ContextWithRequestID(legacy, "typed")); !ok || id != "typed" {
+		t.Errorf("RequestIDFromContext = %q, %v, want the typed key first", id, ok)
+	}
+
+	// A value of another type under the string key is ignored.
+	if _, ok := RequestIDFromContext(context.WithValue(context.Background(), RequestIDField, 42)); ok {
+		t.Error("non-string request id accepted")
+	}
+}
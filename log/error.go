@@ -3,6 +3,7 @@ package log
 import (
 	"errors"
 	"fmt"
+	"io"
 )
 
 type errWithFields interface {
@@ -10,17 +11,24 @@ type errWithFields interface {
 	Origin() error
 }
 
+type stackTracer interface {
+	StackTrace() string
+}
+
 type FieldsError struct {
 	err    error
 	fields Fld
+	stack  stack
+	code   Code
 }
 
 func (e *FieldsError) Error() string {
 	return e.err.Error()
 }
 
-func (e *FieldsError) Is(target error) bool {
-	return errors.Is(e.err, target)
+// Unwrap lets errors.As and errors.Is see the wrapped error.
+func (e *FieldsError) Unwrap() error {
+	return e.err
 }
 
 func (e *FieldsError) Fields() Fld {
@@ -31,27 +39,112 @@ func (e *FieldsError) Origin() error {
 	return e.err
 }
 
-// Wrap error with fields for logging
-func Wrap(msg string, err error, fields Fld) error {
+// Code returns the code set with WithCode, empty when none was set.
+func (e *FieldsError) Code() Code {
+	return e.code
+}
+
+// StackTrace returns the stack captured when the error was first wrapped.
+func (e *FieldsError) StackTrace() string {
+	return e.stack.String()
+}
+
+// Format prints the stack trace after the message for %+v.
+// Other verbs format the message as a string.
+func (e *FieldsError) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		_, _ = io.WriteString(s, e.Error())
+		if s.Flag('+') && len(e.stack) > 0 {
+			_, _ = io.WriteString(s, "\n")
+			_, _ = io.WriteString(s, e.stack.String())
+		}
+	case 's':
+		_, _ = io.WriteString(s, e.Error())
+	case 'q':
+		_, _ = fmt.Fprintf(s, "%q", e.Error())
+	default:
+		_, _ = fmt.Fprintf(s, "%"+string(verb), e.Error())
+	}
+}
+
+// Wrap error with fields for logging.
+// The stack is captured once, when err enters the FieldsError chain.
+// The code of a wrapped errWithFields is kept unless WithCode overrides it.
+func Wrap(msg string, err error, fields Fld, opts ...WrapOption) error {
 	if fields == nil {
 		fields = Fld{}
 	}
-	fieldsErr, ok := err.(errWithFields)
-	if !ok {
-		return &FieldsError{
+
+	var wrapped *FieldsError
+	if fieldsErr, ok := err.(errWithFields); ok {
+		wrapped = &FieldsError{
+			err:    fmt.Errorf("%s: %w", msg, fieldsErr.Origin()),
+			fields: mergeFields(fieldsErr.Fields(), fields),
+			stack:  stackOf(err),
+		}
+		if c, ok := err.(coder); ok {
+			wrapped.code = c.Code()
+		}
+	} else {
+		wrapped = &FieldsError{
 			err:    fmt.Errorf("%s: %w", msg, err),
 			fields: fields,
+			stack:  stackOf(err),
 		}
 	}
 
-	return &FieldsError{
-		err:    fmt.Errorf("%s: %w", msg, fieldsErr.Origin()),
-		fields: mergeFields(fieldsErr.Fields(), fields),
+	for _, opt := range opts {
+		opt(wrapped)
 	}
+
+	return wrapped
+}
+
+// FieldsOf collects the fields of every errWithFields in the chain of err,
+// including errors.Join trees. Outer fields override inner ones and
+// later joined errors override earlier ones. The result is a new map.
+func FieldsOf(err error) Fld {
+	fields := Fld{}
+	collectFields(err, fields)
+
+	return fields
+}
+
+func collectFields(err error, fields Fld) {
+	switch e := err.(type) {
+	case nil:
+		return
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			collectFields(inner, fields)
+		}
+	case interface{ Unwrap() error }:
+		collectFields(e.Unwrap(), fields)
+	}
+
+	if e, ok := err.(errWithFields); ok {
+		for k, v := range e.Fields() {
+			fields[k] = v
+		}
+	}
+}
+
+// stackOf returns the stack already captured in the chain of err or captures a new one.
+func stackOf(err error) stack {
+	var fe *FieldsError
+	if errors.As(err, &fe) && fe.stack != nil {
+		return fe.stack
+	}
+
+	return callers(2)
 }
 
 func mergeFields(fld1, fld2 Fld) Fld {
-	result := fld1
+	result := make(Fld, len(fld1)+len(fld2))
+	for k, v := range fld1 {
+		result[k] = v
+	}
 	for k, v := range fld2 {
 		result[k] = v
 	}
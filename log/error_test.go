@@ -0,0 +1,147 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWrapErrorsAs(t *testing.T) {
+	base := NewError(CodeNotFound, "order not found")
+	err := fmt.Errorf("handler: %w", Wrap("load order", base, Fld{"order_id": 7}))
+
+	var fe *FieldsError
+	if !errors.As(err, &fe) {
+		t.Fatalf("errors.As did not find the FieldsError in %v", err)
+	}
+	if fe.Fields()["order_id"] != 7 {
+		t.Errorf("fields = %v", fe.Fields())
+	}
+	if !errors.Is(err, base) || CodeOf(err) != CodeNotFound {
+		t.Errorf("errors.Is = %v, CodeOf = %q, want the sentinel and its code", errors.Is(err, base), CodeOf(err))
+	}
+	if got := FieldsOf(err); got["order_id"] != 7 {
+		t.Errorf("FieldsOf = %v", got)
+	}
+}
+
+func TestFieldsOfJoin(t *testing.T) {
+	first := Wrap("first", errors.New("boom"), Fld{"a": 1, "shared": "first"})
+	second := Wrap("second", errors.New("bang"), Fld{"b": 2, "shared": "second"})
+	err := Wrap("both", errors.Join(first, fmt.Errorf("wrapped: %w", second)), Fld{"c": 3})
+
+	got := FieldsOf(err)
+	want := Fld{"a": 1, "b": 2, "c": 3, "shared": "second"}
+	if len(got) != len(want) {
+		t.Fatalf("FieldsOf = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("FieldsOf[%q] = %v, want %v", k, got[k], v)
+		}
+	}
+
+	// Outer fields override inner ones.
+	if got := FieldsOf(Wrap("outer", first, Fld{"shared": "outer"})); got["shared"] != "outer" {
+		t.Errorf("FieldsOf = %v, want the outer value", got)
+	}
+}
+
+func TestRewrapKeepsInnerFields(t *testing.T) {
+	innerFields := Fld{"order_id": 7}
+	inner := Wrap("load", errors.New("boom"), innerFields)
+
+	outer := Wrap("handle", inner, Fld{"order_id": 8, "user_id": 1})
+
+	if len(innerFields) != 1 || innerFields["order_id"] != 7 {
+		t.Errorf("inner map was mutated: %v", innerFields)
+	}
+	if got := inner.(*FieldsError).Fields(); len(got) != 1 || got["order_id"] != 7 {
+		t.Errorf("inner fields = %v", got)
+	}
+	if got := outer.(*FieldsError).Fields(); got["order_id"] != 8 || got["user_id"] != 1 {
+		t.Errorf("outer fields = %v", got)
+	}
+
+	// FieldsOf returns a copy.
+	FieldsOf(outer)["order_id"] = 9
+	if got := outer.(*FieldsError).Fields(); got["order_id"] != 8 {
+		t.Errorf("FieldsOf result shares the error fields: %v", got)
+	}
+}
+
+// wrapHere wraps err so the stack starts in a known function.
+func wrapHere(err error) error {
+	return Wrap("wrapped", err, nil)
+}
+
+func TestFieldsErrorFormat(t *testing.T) {
+	err := wrapHere(errors.New("boom"))
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "%v", want: "wrapped: boom"},
+		{format: "%s", want: "wrapped: boom"},
+		{format: "%q", want: `"wrapped: boom"`},
+		{format: "%x", want: fmt.Sprintf("%x", "wrapped: boom")},
+		{format: "%d", want: "%!d(string=wrapped: boom)"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf(tt.format, err); got != tt.want {
+			t.Errorf("Sprintf(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+
+	got := fmt.Sprintf("%+v", err)
+	if !strings.HasPrefix(got, "wrapped: boom\n") || !strings.Contains(got, "log.wrapHere\n\t") ||
+		!strings.Contains(got, "error_test.go:") {
+		t.Errorf("%%+v = %q, want the message and the stack", got)
+	}
+}
+
+func TestWithErrStack(t *testing.T) {
+	l, buf := newBufferLog(t, Config{})
+
+	l.WithErr(wrapHere(errors.New("boom"))).Error("failed")
+	l.WithErr(errors.New("plain")).Error("failed")
+
+	got := entries(t, buf)
+	if stack, _ := got[0][ErrorStackField].(string); !strings.Contains(stack, "log.wrapHere") {
+		t.Errorf("%s = %q, want the wrap site", ErrorStackField, stack)
+	}
+	if _, ok := got[1][ErrorStackField]; ok {
+		t.Errorf("plain error has a stack: %v", got[1])
+	}
+}
+
+func TestRewrapReusesStack(t *testing.T) {
+	inner := wrapHere(errors.New("boom"))
+	outer := Wrap("outer", fmt.Errorf("context: %w", inner), Fld{"n": 1})
+
+	innerStack := inner.(*FieldsError).StackTrace()
+	if got := outer.(*FieldsError).StackTrace(); got != innerStack || !strings.Contains(got, "log.wrapHere") {
+		t.Errorf("outer stack = %q, want the stack of the first wrap", got)
+	}
+}
+
+func TestSetStackCapture(t *testing.T) {
+	SetStackCapture(false)
+	t.Cleanup(func() { SetStackCapture(true) })
+	l, buf := newBufferLog(t, Config{})
+
+	err := wrapHere(errors.New("boom"))
+	l.WithErr(err).Error("failed")
+
+	if got := err.(*FieldsError).StackTrace(); got != "" {
+		t.Errorf("stack captured while disabled: %q", got)
+	}
+	if got := fmt.Sprintf("%+v", err); got != "wrapped: boom" {
+		t.Errorf("%%+v = %q, want the message only", got)
+	}
+	if _, ok := lastEntry(t, buf)[ErrorStackField]; ok {
+		t.Errorf("entry has a stack: %s", buf)
+	}
+}
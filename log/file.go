@@ -0,0 +1,277 @@
+package log
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	compressSuffix   = ".gz"
+	megabyte         = 1024 * 1024
+	// rotateRetryInterval is how long a file that failed to rotate keeps growing before the next attempt.
+	rotateRetryInterval = time.Minute
+)
+
+var (
+	filesMu sync.Mutex
+	files   = map[string]*rotatingFile{}
+)
+
+// Reopen closes and reopens every log file opened by this package.
+// InstallSignalHandlers calls it on SIGHUP so that external rotation (logrotate "create" mode) works.
+func Reopen() error {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	var errs []error
+	for _, f := range files {
+		if err := f.Reopen(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// rotatingFile is a zapcore.WriteSyncer over a file that can be reopened in place
+// and optionally rotated by size. Backups beyond maxAge or maxBackups are removed
+// after each rotation. Writes and reopen share one lock, so no message is lost
+// or split while the file is being swapped.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	mu          sync.Mutex
+	file        *os.File
+	size        int64
+	rotateRetry time.Time
+}
+
+// openFile opens path for appending, sharing one writer per path within the process.
+// Opening a shared path again with other rotation settings is an error.
+func openFile(path string, cfg Config) (*rotatingFile, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log file path %q: %w", path, err)
+	}
+
+	f := &rotatingFile{
+		path:       abs,
+		maxSize:    int64(cfg.FileMaxSize) * megabyte,
+		maxAge:     cfg.FileMaxAge,
+		maxBackups: cfg.FileMaxBackups,
+		compress:   cfg.FileCompress,
+	}
+
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	if opened, ok := files[abs]; ok {
+		if !opened.sameRotation(f) {
+			return nil, fmt.Errorf("log file %q is already open with other rotation settings", abs)
+		}
+		return opened, nil
+	}
+
+	if err = f.open(); err != nil {
+		return nil, err
+	}
+	files[abs] = f
+
+	return f, nil
+}
+
+func (f *rotatingFile) sameRotation(other *rotatingFile) bool {
+	return f.maxSize == other.maxSize && f.maxAge == other.maxAge &&
+		f.maxBackups == other.maxBackups && f.compress == other.compress
+}
+
+func (f *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	old := f.file
+	f.file = file
+	f.size = info.Size()
+	f.rotateRetry = time.Time{}
+	if old != nil {
+		_ = old.Close()
+	}
+
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// A failed rotation must not lose messages: they go to the current file,
+	// the error is reported once and rotation is retried later.
+	var rotateErr error
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize && !time.Now().Before(f.rotateRetry) {
+		if rotateErr = f.rotate(); rotateErr != nil {
+			f.rotateRetry = time.Now().Add(rotateRetryInterval)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, errors.Join(err, rotateErr)
+}
+
+func (f *rotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Sync()
+}
+
+// Reopen swaps the underlying file for a freshly opened one at the same path.
+// The old descriptor is kept if the new one cannot be opened.
+func (f *rotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.open()
+}
+
+// rotate moves the current file aside and opens a new one. Must be called with mu held.
+func (f *rotatingFile) rotate() error {
+	// Backup names have millisecond precision, a name already taken is never overwritten.
+	now := time.Now()
+	backup := f.backupName(now)
+	for i := 1; backupExists(backup); i++ {
+		backup = f.backupName(now.Add(time.Duration(i) * time.Millisecond))
+	}
+	if err := os.Rename(f.path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	go f.cleanup(backup)
+
+	return nil
+}
+
+// backupName formats t in UTC, as cleanup parses it.
+func (f *rotatingFile) backupName(t time.Time) string {
+	dir, prefix, ext := f.nameParts()
+	return filepath.Join(dir, fmt.Sprintf("%s%s%s", prefix, t.UTC().Format(backupTimeFormat), ext))
+}
+
+func backupExists(path string) bool {
+	for _, p := range []string{path, path + compressSuffix} {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *rotatingFile) nameParts() (dir, prefix, ext string) {
+	dir = filepath.Dir(f.path)
+	name := filepath.Base(f.path)
+	ext = filepath.Ext(name)
+	prefix = strings.TrimSuffix(name, ext) + "-"
+
+	return dir, prefix, ext
+}
+
+// cleanup compresses the fresh backup and removes backups beyond the age and count limits.
+func (f *rotatingFile) cleanup(backup string) {
+	if f.compress {
+		_ = compressFile(backup)
+	}
+
+	if f.maxAge <= 0 && f.maxBackups <= 0 {
+		return
+	}
+
+	dir, prefix, ext := f.nameParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type backupFile struct {
+		path string
+		t    time.Time
+	}
+	var backups []backupFile
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), compressSuffix)
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(dir, e.Name()), t: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].t.After(backups[j].t) })
+
+	for i, b := range backups {
+		expired := f.maxAge > 0 && time.Since(b.t) > f.maxAge
+		excess := f.maxBackups > 0 && i >= f.maxBackups
+		if expired || excess {
+			_ = os.Remove(b.path)
+		}
+	}
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+
+	dst, err := os.OpenFile(path+compressSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(dst.Name())
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(dst.Name())
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
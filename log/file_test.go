@@ -0,0 +1,191 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFile(t *testing.T, f *rotatingFile) *rotatingFile {
+	t.Helper()
+
+	f.path = filepath.Join(t.TempDir(), "app.log")
+	if err := f.open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.file.Close() })
+
+	return f
+}
+
+func backups(t *testing.T, f *rotatingFile) []string {
+	t.Helper()
+
+	dir, prefix, _ := f.nameParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			names = append(names, e.Name())
+		}
+	}
+
+	return names
+}
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	f := newTestFile(t, &rotatingFile{maxSize: 10})
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current, err := os.ReadFile(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current) != "third\n" {
+		t.Errorf("current file = %q", current)
+	}
+	if got := backups(t, f); len(got) != 2 {
+		t.Errorf("backups = %v, want 2", got)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	f := newTestFile(t, &rotatingFile{maxSize: 10})
+
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	// Renaming a file that was removed from under the writer fails.
+	if err := os.Remove(f.path); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Write([]byte("kept\n"))
+	if n != 5 || err == nil {
+		t.Fatalf("Write = %d, %v, want the message written and the rotation error", n, err)
+	}
+	n, err = f.Write([]byte("kept too\n"))
+	if n != 9 || err != nil {
+		t.Fatalf("Write = %d, %v, rotation should not be retried right away", n, err)
+	}
+
+	// The removed file is still open, its size shows what was written to it.
+	info, err := f.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 24 {
+		t.Errorf("file size = %d, messages were lost", info.Size())
+	}
+
+	// Once the retry interval passed, rotation is attempted again.
+	if err = os.WriteFile(f.path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f.rotateRetry = time.Now().Add(-time.Second)
+	if _, err = f.Write([]byte("rotated\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := backups(t, f); len(got) != 1 {
+		t.Errorf("backups = %v, want 1", got)
+	}
+}
+
+func TestRotatingFileCleanup(t *testing.T) {
+	// Backup names must be compared in UTC whatever the local zone is.
+	local := time.Local
+	time.Local = time.FixedZone("UTC+5", 5*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	f := newTestFile(t, &rotatingFile{maxAge: time.Hour, maxBackups: 2})
+	now := time.Now()
+	for _, age := range []time.Duration{time.Minute, 10 * time.Minute, 20 * time.Minute, 2 * time.Hour} {
+		if err := os.WriteFile(f.backupName(now.Add(-age)), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.cleanup(f.backupName(now.Add(-time.Minute)))
+
+	got := backups(t, f)
+	want := []string{filepath.Base(f.backupName(now.Add(-10 * time.Minute))), filepath.Base(f.backupName(now.Add(-time.Minute)))}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("backups = %v, want %v", got, want)
+	}
+}
+
+func TestRotatingFileCleanupByAge(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC-5", -5*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	f := newTestFile(t, &rotatingFile{maxAge: time.Hour})
+	now := time.Now()
+	fresh := f.backupName(now.Add(-30 * time.Minute))
+	expired := f.backupName(now.Add(-90 * time.Minute))
+	for _, path := range []string{fresh, expired} {
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.cleanup(fresh)
+
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("fresh backup removed: %v", err)
+	}
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expired backup kept: %v", err)
+	}
+}
+
+func TestRotatingFileCompress(t *testing.T) {
+	f := newTestFile(t, &rotatingFile{compress: true})
+	backup := f.backupName(time.Now())
+	if err := os.WriteFile(backup, []byte("line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f.cleanup(backup)
+
+	if _, err := os.Stat(backup + compressSuffix); err != nil {
+		t.Errorf("compressed backup missing: %v", err)
+	}
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Errorf("uncompressed backup kept: %v", err)
+	}
+}
+
+func TestOpenFileSharesPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.log")
+	cfg := Config{FileMaxSize: 1, FileMaxBackups: 3}
+
+	f, err := openFile(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		filesMu.Lock()
+		delete(files, f.path)
+		filesMu.Unlock()
+		_ = f.file.Close()
+	})
+
+	if same, err := openFile(path, cfg); err != nil || same != f {
+		t.Errorf("openFile with the same settings = %p, %v, want the shared writer", same, err)
+	}
+	cfg.FileMaxAge = time.Hour
+	if _, err = openFile(path, cfg); err == nil || !strings.Contains(err.Error(), "other rotation settings") {
+		t.Errorf("openFile with other settings = %v, want an error", err)
+	}
+}
@@ -0,0 +1,71 @@
+package log
+
+import (
+	"context"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"sync"
+)
+
+// Flusher is anything holding buffered log entries that must be written before exit.
+type Flusher interface {
+	Flush(ctx context.Context)
+}
+
+var (
+	flushersMu sync.Mutex
+	flushers   = map[Flusher]struct{}{}
+)
+
+// osExit is replaced in tests.
+var osExit = os.Exit
+
+// RegisterFlusher registers f to be flushed by FlushAll and after a Fatal entry is written.
+// The returned function removes the registration.
+func RegisterFlusher(f Flusher) (unregister func()) {
+	flushersMu.Lock()
+	flushers[f] = struct{}{}
+	flushersMu.Unlock()
+
+	return func() {
+		flushersMu.Lock()
+		delete(flushers, f)
+		flushersMu.Unlock()
+	}
+}
+
+// FlushAll flushes every registered Flusher until ctx is done.
+func FlushAll(ctx context.Context) {
+	flushersMu.Lock()
+	registered := make([]Flusher, 0, len(flushers))
+	for f := range flushers {
+		registered = append(registered, f)
+	}
+	flushersMu.Unlock()
+
+	for _, f := range registered {
+		f.Flush(ctx)
+	}
+}
+
+// exitHook is the zap fatal hook. It runs after the Fatal entry is written, so
+// sinks that only queue entries get it before they are flushed.
+type exitHook struct {
+	sync func() error
+}
+
+func (h *exitHook) OnWrite(*zapcore.CheckedEntry, []zapcore.Field) {
+	flushAndExit(h.sync)
+}
+
+// flushAndExit flushes every registered Flusher, then sync, and exits the process.
+func flushAndExit(sync func() error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultAsyncFlushTimeout)
+	FlushAll(ctx)
+	cancel()
+	if sync != nil {
+		_ = sync()
+	}
+
+	osExit(1)
+}
@@ -0,0 +1,122 @@
+package log
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+var (
+	defaultLog  atomic.Pointer[Log]
+	defaultOnce sync.Once
+
+	signalsMu   sync.Mutex
+	signalsStop func()
+)
+
+// L returns the default logger used by the package functions, LogPanic and Go.
+// Until SetDefault is called it is Default() reporting the caller of its methods.
+func L() *Log {
+	if l := defaultLog.Load(); l != nil {
+		return l
+	}
+	defaultOnce.Do(func() {
+		l := Default()
+		l.logger = l.logger.WithOptions(zap.AddCallerSkip(1 - DefaultCallerSkip))
+		l.loggerStd = l.logger.Desugar()
+		defaultLog.CompareAndSwap(nil, l)
+	})
+
+	return defaultLog.Load()
+}
+
+// SetDefault replaces the default logger, nil is ignored.
+// Package functions report their caller when l reports the caller of its methods,
+// i.e. with Config.CallerSkip set to 1.
+func SetDefault(l *Log) {
+	if l != nil {
+		defaultLog.Store(l)
+	}
+}
+
+// Debug logs msg with the context fields of ctx through the default logger.
+func Debug(ctx context.Context, msg string, keysAndValues ...any) {
+	ctxLogger(ctx).Debugw(msg, keysAndValues...)
+}
+
+// Info logs msg with the context fields of ctx through the default logger.
+func Info(ctx context.Context, msg string, keysAndValues ...any) {
+	ctxLogger(ctx).Infow(msg, keysAndValues...)
+}
+
+// Warn logs msg with the context fields of ctx through the default logger.
+func Warn(ctx context.Context, msg string, keysAndValues ...any) {
+	ctxLogger(ctx).Warnw(msg, keysAndValues...)
+}
+
+// Error logs msg with the context fields of ctx and err through the default logger.
+func Error(ctx context.Context, err error, msg string, keysAndValues ...any) {
+	l := ctxLogger(ctx)
+	if err != nil {
+		l = l.WithErr(err)
+	}
+	l.Errorw(msg, keysAndValues...)
+}
+
+// ctxLogger returns the default logger for ctx, skipping the package function in the caller.
+func ctxLogger(ctx context.Context) *Log {
+	return skipCaller(L().WithCtx(ctx))
+}
+
+func skipCaller(l *Log) *Log {
+	return l.copyWithEntry(*l.logger.WithOptions(zap.AddCallerSkip(1)))
+}
+
+// InstallSignalHandlers reopens log files on SIGHUP, reporting through the default logger.
+// Importing the package does not touch signals, applications opt in by calling it.
+// Calling it again is a no-op, the returned function removes the handler.
+func InstallSignalHandlers() (stop func()) {
+	signalsMu.Lock()
+	defer signalsMu.Unlock()
+
+	if signalsStop != nil {
+		return signalsStop
+	}
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+			case <-done:
+				return
+			}
+			if err := Reopen(); err != nil {
+				L().Errorf("Log Rotate failed: %v", err)
+				continue
+			}
+			L().Info("Log Rotate signal received")
+		}
+	}()
+
+	var once sync.Once
+	signalsStop = func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+
+			signalsMu.Lock()
+			signalsStop = nil
+			signalsMu.Unlock()
+		})
+	}
+
+	return signalsStop
+}
@@ -0,0 +1,133 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// setDefault makes l the default logger for the test.
+func setDefault(t *testing.T, l *Log) {
+	t.Helper()
+
+	orig := defaultLog.Load()
+	SetDefault(l)
+	t.Cleanup(func() { defaultLog.Store(orig) })
+}
+
+func TestSetDefault(t *testing.T) {
+	before := L()
+	if before == nil || L() != before {
+		t.Fatal("L does not return a stable default logger")
+	}
+
+	l, _ := newBufferLog(t, Config{})
+	setDefault(t, l)
+	if L() != l {
+		t.Error("L does not return the logger set by SetDefault")
+	}
+	SetDefault(nil)
+	if L() != l {
+		t.Error("SetDefault(nil) replaced the default logger")
+	}
+	if Default() == l {
+		t.Error("Default returned the logger set by SetDefault")
+	}
+}
+
+func TestPackageFunctions(t *testing.T) {
+	l, buf := newBufferLog(t, Config{LogLevel: "debug", CallerSkip: 1})
+	setDefault(t, l)
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+
+	Debug(ctx, "debug", "n", 1)
+	Info(ctx, "info")
+	Warn(ctx, "warn")
+	Error(ctx, Wrap("load", errors.New("boom"), Fld{"order_id": 7}), "error")
+	Error(ctx, nil, "no error")
+	L().Info("method")
+
+	got := entries(t, buf)
+	if len(got) != 6 {
+		t.Fatalf("got %d entries, want 6: %s", len(got), buf)
+	}
+	for i, want := range []struct{ level, msg string }{
+		{"DEBUG", "debug"}, {"INFO", "info"}, {"WARN", "warn"}, {"ERROR", "error"}, {"ERROR", "no error"}, {"INFO", "method"},
+	} {
+		if got[i]["level"] != want.level || got[i]["message"] != want.msg {
+			t.Errorf("entry %d = %v, want %s %q", i, got[i], want.level, want.msg)
+		}
+		if caller, _ := got[i]["caller"].(string); !strings.HasPrefix(caller, "log/global_test.go:") {
+			t.Errorf("entry %d caller = %q, want the call site", i, caller)
+		}
+		if i < 5 && got[i][RequestIDField] != "req-1" {
+			t.Errorf("entry %d lost the context fields: %v", i, got[i])
+		}
+	}
+	if got[0]["n"] != float64(1) || got[3]["error"] != "load: boom" || got[3]["order_id"] != float64(7) {
+		t.Errorf("fields were lost: %v", got)
+	}
+	if _, ok := got[4]["error"]; ok {
+		t.Errorf("nil error was logged: %v", got[4])
+	}
+}
+
+func TestInstallSignalHandlers(t *testing.T) {
+	l, buf := newSyncBufferLog(t)
+	setDefault(t, l)
+	received := func() int {
+		return len(messages(t, buf, "INFO"))
+	}
+	waitFor := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for received() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d rotate messages, want %d", received(), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// Keeps SIGHUP from terminating the test binary once the handler is stopped.
+	keep := make(chan os.Signal, 4)
+	signal.Notify(keep, syscall.SIGHUP)
+	defer signal.Stop(keep)
+
+	stop := InstallSignalHandlers()
+	again := InstallSignalHandlers()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(1)
+	<-keep
+
+	// The second call did not start another handler.
+	time.Sleep(10 * time.Millisecond)
+	if n := received(); n != 1 {
+		t.Errorf("got %d rotate messages for one signal", n)
+	}
+
+	again()
+	stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	<-keep
+	time.Sleep(10 * time.Millisecond)
+	if n := received(); n != 1 {
+		t.Errorf("got %d rotate messages after stop, want 1", n)
+	}
+
+	// After stop the handler can be installed again.
+	stop = InstallSignalHandlers()
+	defer stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(2)
+}
@@ -0,0 +1,219 @@
+// Package grpclog provides gRPC interceptors logging through log.Log.
+// Server interceptors read or generate a request id, put it into the context for
+// log.Log.WithCtx, recover panics and log every call with the go-grpc-middleware fields.
+// Errors returned by handlers without a gRPC status get one from their log.Code,
+// the messages of server errors are replaced with the code name so internals do not leak.
+// Client interceptors propagate the request id and debug flag to the callee,
+// servers only honor the flag with WithDebugMetadata.
+package grpclog
+
+import (
+	"context"
+	"github.com/D1sordxr/packages/log"
+	"github.com/google/uuid"
+	grpcmiddleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// RequestIDMetadataKey is the metadata key carrying the request id.
+	RequestIDMetadataKey = "x-request-id"
+	// maxRequestIDLen bounds ids taken from clients, longer ones are replaced.
+	maxRequestIDLen = 128
+)
+
+type options struct {
+	loggingOpts []logging.Option
+	newID       func() string
+	debug       bool
+}
+
+// Option configures the interceptors.
+type Option func(*options)
+
+// WithLoggingOptions passes options to the go-grpc-middleware logging interceptor.
+func WithLoggingOptions(opts ...logging.Option) Option {
+	return func(o *options) {
+		o.loggingOpts = append(o.loggingOpts, opts...)
+	}
+}
+
+// WithRequestIDGenerator replaces the uuid based request id generator.
+func WithRequestIDGenerator(f func() string) Option {
+	return func(o *options) {
+		o.newID = f
+	}
+}
+
+// WithDebugMetadata lets callers enable debug logs for their call with
+// log.DebugMetadataKey. It is off by default, any client could turn it on.
+func WithDebugMetadata() Option {
+	return func(o *options) {
+		o.debug = true
+	}
+}
+
+func evaluateOptions(opts []Option) *options {
+	o := &options{newID: uuid.NewString}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Logger adapts l to the go-grpc-middleware logging interface,
+// adding the context fields of each call.
+func Logger(l *log.Log) logging.Logger {
+	return logging.LoggerFunc(func(ctx context.Context, lvl logging.Level, msg string, fields ...any) {
+		l.WithCtx(ctx).LogGRPC(ctx, lvl, msg, fields...)
+	})
+}
+
+// UnaryServerInterceptor returns a server interceptor handling request ids, panics and logging.
+func UnaryServerInterceptor(l *log.Log, opts ...Option) grpc.UnaryServerInterceptor {
+	o := evaluateOptions(opts)
+	logInterceptor := logging.UnaryServerInterceptor(Logger(l), o.loggingOpts...)
+	recoverInterceptor := recovery.UnaryServerInterceptor(recovery.WithRecoveryHandlerContext(recoverPanic(l)))
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx = incomingContext(ctx, o)
+
+		return logInterceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			resp, err := recoverInterceptor(ctx, req, info, handler)
+			return resp, statusError(err)
+		})
+	}
+}
+
+// StreamServerInterceptor returns a stream server interceptor handling request ids, panics and logging.
+func StreamServerInterceptor(l *log.Log, opts ...Option) grpc.StreamServerInterceptor {
+	o := evaluateOptions(opts)
+	logInterceptor := logging.StreamServerInterceptor(Logger(l), o.loggingOpts...)
+	recoverInterceptor := recovery.StreamServerInterceptor(recovery.WithRecoveryHandlerContext(recoverPanic(l)))
+
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := grpcmiddleware.WrapServerStream(stream)
+		wrapped.WrappedContext = incomingContext(stream.Context(), o)
+
+		return logInterceptor(srv, wrapped, info, func(srv any, stream grpc.ServerStream) error {
+			return statusError(recoverInterceptor(srv, stream, info, handler))
+		})
+	}
+}
+
+// UnaryClientInterceptor returns a client interceptor propagating the request id and logging calls.
+func UnaryClientInterceptor(l *log.Log, opts ...Option) grpc.UnaryClientInterceptor {
+	o := evaluateOptions(opts)
+	logInterceptor := logging.UnaryClientInterceptor(Logger(l), o.loggingOpts...)
+
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		return logInterceptor(outgoingContext(ctx, o), method, req, reply, cc, invoker, callOpts...)
+	}
+}
+
+// StreamClientInterceptor returns a stream client interceptor propagating the request id and logging calls.
+func StreamClientInterceptor(l *log.Log, opts ...Option) grpc.StreamClientInterceptor {
+	o := evaluateOptions(opts)
+	logInterceptor := logging.StreamClientInterceptor(Logger(l), o.loggingOpts...)
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return logInterceptor(outgoingContext(ctx, o), desc, cc, method, streamer, callOpts...)
+	}
+}
+
+// incomingContext takes the request id from metadata or generates one,
+// echoes it in the response header and enables per-call debug logs if allowed.
+func incomingContext(ctx context.Context, o *options) context.Context {
+	var requestID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDMetadataKey); len(ids) > 0 {
+			requestID = ids[0]
+		}
+	}
+	if requestID == "" || len(requestID) > maxRequestIDLen {
+		requestID = o.newID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDMetadataKey, requestID))
+
+	ctx = log.ContextWithRequestID(ctx, requestID)
+	if o.debug {
+		ctx = log.GRPCDebugContext(ctx)
+	}
+
+	return ctx
+}
+
+// outgoingContext adds the request id and debug flag of ctx to outgoing metadata.
+func outgoingContext(ctx context.Context, o *options) context.Context {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(RequestIDMetadataKey)) > 0 {
+		return ctx
+	}
+
+	requestID, ok := log.RequestIDFromContext(ctx)
+	if !ok || requestID == "" {
+		requestID = o.newID()
+		ctx = log.ContextWithRequestID(ctx, requestID)
+	}
+
+	pairs := []string{RequestIDMetadataKey, requestID}
+	if log.DebugFromContext(ctx) {
+		pairs = append(pairs, log.DebugMetadataKey, "1")
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
+
+// StatusCode maps a log.Code to a gRPC status code.
+func StatusCode(code log.Code) codes.Code {
+	switch code {
+	case "":
+		return codes.OK
+	case log.CodeNotFound:
+		return codes.NotFound
+	case log.CodeConflict:
+		return codes.AlreadyExists
+	case log.CodeInvalid:
+		return codes.InvalidArgument
+	case log.CodeUnavailable:
+		return codes.Unavailable
+	case log.CodeCanceled:
+		return codes.Canceled
+	case log.CodeDeadlineExceeded:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
+
+// statusError converts err to a status error using its log.Code,
+// errors already carrying a status are returned as is. Like httplog.Error it
+// only sends the message of client errors, server errors carry the code name.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	code := StatusCode(log.CodeOf(err))
+	switch code {
+	case codes.Internal, codes.Unavailable, codes.DeadlineExceeded:
+		return status.Error(code, code.String())
+	default:
+		return status.Error(code, err.Error())
+	}
+}
+
+func recoverPanic(l *log.Log) recovery.RecoveryHandlerFuncContext {
+	return func(ctx context.Context, p any) error {
+		l.WithCtx(ctx).LogPanic(p)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
@@ -0,0 +1,346 @@
+package grpclog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/D1sordxr/packages/log"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer collects log lines written by server and client goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+// entries returns the logged entries with the given message.
+func (b *syncBuffer) entries(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var result []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(b.buf.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		if m, _ := entry["message"].(string); strings.HasPrefix(m, msg) {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+func newTestLog(t *testing.T) (*log.Log, *syncBuffer) {
+	t.Helper()
+
+	buf := &syncBuffer{}
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "message", LevelKey: "level", EncodeLevel: zapcore.CapitalLevelEncoder})
+	l := log.NewWithCore(log.Config{LogLevel: "info"}, zapcore.NewCore(enc, zapcore.AddSync(buf), zapcore.DebugLevel))
+	if l == nil {
+		t.Fatal("NewWithCore returned nil")
+	}
+
+	return l, buf
+}
+
+// healthServer runs handle for every call and logs through l with the call context.
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	l      *log.Log
+	handle func(ctx context.Context) error
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func (s *healthServer) Check(ctx context.Context, _ *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if err := s.serve(ctx); err != nil {
+		return nil, err
+	}
+
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (s *healthServer) Watch(_ *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	if err := s.serve(stream.Context()); err != nil {
+		return err
+	}
+
+	return stream.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+}
+
+func (s *healthServer) serve(ctx context.Context) error {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	s.l.WithCtx(ctx).Debug("handler debug")
+	s.l.WithCtx(ctx).Info("handler called")
+	if s.handle != nil {
+		return s.handle(ctx)
+	}
+
+	return nil
+}
+
+// lastCtx returns the context of the last call.
+func (s *healthServer) lastCtx() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ctx
+}
+
+// startServer serves srv over bufconn with the server interceptors.
+func startServer(t *testing.T, srv *healthServer, opts ...Option) *bufconn.Listener {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(srv.l, opts...)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(srv.l, opts...)),
+	)
+	grpc_health_v1.RegisterHealthServer(s, srv)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	return lis
+}
+
+func dial(t *testing.T, lis *bufconn.Listener, opts ...grpc.DialOption) grpc_health_v1.HealthClient {
+	t.Helper()
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+func TestServerRequestID(t *testing.T) {
+	l, buf := newTestLog(t)
+	srv := &healthServer{l: l}
+	client := dial(t, startServer(t, srv, WithRequestIDGenerator(func() string { return "generated" })))
+
+	tests := []struct {
+		name string
+		sent []string
+		want string
+	}{
+		{name: "generated", want: "generated"},
+		{name: "from client", sent: []string{"client-id"}, want: "client-id"},
+		{name: "too long", sent: []string{strings.Repeat("x", maxRequestIDLen+1)}, want: "generated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.sent != nil {
+				ctx = metadata.AppendToOutgoingContext(ctx, RequestIDMetadataKey, tt.sent[0])
+			}
+
+			var header metadata.MD
+			if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.Header(&header)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := header.Get(RequestIDMetadataKey); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("response header = %q, want %q", got, tt.want)
+			}
+			if id, _ := log.RequestIDFromContext(srv.lastCtx()); id != tt.want {
+				t.Errorf("handler request id = %q, want %q", id, tt.want)
+			}
+			called := buf.entries(t, "handler called")
+			if last := called[len(called)-1]; last[log.RequestIDField] != tt.want {
+				t.Errorf("handler entry = %v, want request id %q", last, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerDebugMetadata(t *testing.T) {
+	for _, allowed := range []bool{false, true} {
+		l, buf := newTestLog(t)
+		srv := &healthServer{l: l}
+		var opts []Option
+		if allowed {
+			opts = append(opts, WithDebugMetadata())
+		}
+		client := dial(t, startServer(t, srv, opts...))
+
+		ctx := metadata.AppendToOutgoingContext(context.Background(), log.DebugMetadataKey, "1")
+		if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := len(buf.entries(t, "handler debug")); got != 0 != allowed {
+			t.Errorf("WithDebugMetadata %v: logged %d debug entries", allowed, got)
+		}
+	}
+}
+
+func TestServerPanic(t *testing.T) {
+	l, buf := newTestLog(t)
+	srv := &healthServer{l: l, handle: func(context.Context) error { panic("boom") }}
+	client := dial(t, startServer(t, srv))
+
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if st, _ := status.FromError(err); st.Code() != codes.Internal || st.Message() != "internal error" {
+		t.Errorf("status = %v, want Internal without details", err)
+	}
+	if panics := buf.entries(t, "Panic recovered: boom"); len(panics) != 1 || panics[0]["level"] != "ERROR" {
+		t.Errorf("panic entries = %v", panics)
+	}
+
+	// The stream interceptor recovers too.
+	stream, err := client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if st, _ := status.FromError(err); st.Code() != codes.Internal {
+		t.Errorf("stream status = %v, want Internal", err)
+	}
+}
+
+func TestServerErrorCodes(t *testing.T) {
+	l, _ := newTestLog(t)
+	var handlerErr error
+	srv := &healthServer{l: l, handle: func(context.Context) error { return handlerErr }}
+	client := dial(t, startServer(t, srv))
+
+	handlerErr = log.NewError(log.CodeNotFound, "unknown service")
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if st, _ := status.FromError(err); st.Code() != codes.NotFound || st.Message() != "unknown service" {
+		t.Errorf("status = %v, want NotFound with the message", err)
+	}
+
+	handlerErr = errors.New("pq: password authentication failed")
+	_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if st, _ := status.FromError(err); st.Code() != codes.Internal || strings.Contains(st.Message(), "pq") {
+		t.Errorf("status = %v, want Internal without the message", err)
+	}
+}
+
+func TestClientPropagation(t *testing.T) {
+	serverLog, _ := newTestLog(t)
+	srv := &healthServer{l: serverLog}
+	lis := startServer(t, srv, WithDebugMetadata())
+	clientLog, _ := newTestLog(t)
+	client := dial(t, lis,
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(clientLog, WithRequestIDGenerator(func() string { return "client-generated" }))),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor(clientLog)),
+	)
+
+	// The request id and debug flag of the caller reach the callee.
+	ctx := log.ContextWithDebug(log.ContextWithRequestID(context.Background(), "req-7"))
+	if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := log.RequestIDFromContext(srv.lastCtx()); id != "req-7" || !log.DebugFromContext(srv.lastCtx()) {
+		t.Errorf("callee request id %q, debug %v, want req-7 and debug", id, log.DebugFromContext(srv.lastCtx()))
+	}
+
+	// Without an id the client generates one.
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := log.RequestIDFromContext(srv.lastCtx()); id != "client-generated" || log.DebugFromContext(srv.lastCtx()) {
+		t.Errorf("callee request id %q, want the generated one without debug", id)
+	}
+
+	// Streams carry the id as well.
+	stream, err := client.Watch(log.ContextWithRequestID(context.Background(), "req-8"), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := log.RequestIDFromContext(srv.lastCtx()); id != "req-8" {
+		t.Errorf("stream callee request id %q, want req-8", id)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    codes.Code
+		message string
+	}{
+		{
+			name:    "client error",
+			err:     log.Wrap("load order", log.NewError(log.CodeNotFound, "order not found"), nil),
+			code:    codes.NotFound,
+			message: "load order: order not found",
+		},
+		{
+			name:    "internal",
+			err:     errors.New("pq: password authentication failed for postgres://app:pw@db"),
+			code:    codes.Internal,
+			message: "Internal",
+		},
+		{
+			name:    "unavailable",
+			err:     log.NewError(log.CodeUnavailable, "dial tcp 10.0.0.7:5432: connection refused"),
+			code:    codes.Unavailable,
+			message: "Unavailable",
+		},
+		{
+			name:    "canceled",
+			err:     fmt.Errorf("query: %w", context.Canceled),
+			code:    codes.Canceled,
+			message: "query: context canceled",
+		},
+		{
+			name:    "deadline",
+			err:     fmt.Errorf("query: %w", context.DeadlineExceeded),
+			code:    codes.DeadlineExceeded,
+			message: "DeadlineExceeded",
+		},
+		{
+			name:    "status",
+			err:     status.Error(codes.PermissionDenied, "no access"),
+			code:    codes.PermissionDenied,
+			message: "no access",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, ok := status.FromError(statusError(tt.err))
+			if !ok || st.Code() != tt.code || st.Message() != tt.message {
+				t.Errorf("status = %v %q, want %v %q", st.Code(), st.Message(), tt.code, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// Package httplog provides net/http middleware logging through log.Log.
+// It reads or generates X-Request-ID, puts it into the request context for
+// log.Log.WithCtx, writes an access log line per request and recovers panics.
+// The log.DebugHeader flag is only honored with WithDebugHeader.
+package httplog
+
+import (
+	"github.com/D1sordxr/packages/log"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+const (
+	// RequestIDHeader is the header carrying the request id.
+	RequestIDHeader = "X-Request-ID"
+	// maxRequestIDLen bounds ids taken from clients, longer ones are replaced.
+	maxRequestIDLen = 128
+	// StatusClientClosedRequest is the nginx status for requests canceled by the client.
+	StatusClientClosedRequest = 499
+)
+
+type options struct {
+	newID func() string
+	route func(r *http.Request) string
+	debug bool
+}
+
+// Option configures the middleware.
+type Option func(*options)
+
+// WithRequestIDGenerator replaces the uuid based request id generator.
+func WithRequestIDGenerator(f func() string) Option {
+	return func(o *options) {
+		o.newID = f
+	}
+}
+
+// WithRouteFunc sets how the route is reported, by default the ServeMux pattern
+// or the path when no pattern matched.
+func WithRouteFunc(f func(r *http.Request) string) Option {
+	return func(o *options) {
+		o.route = f
+	}
+}
+
+// WithDebugHeader lets callers enable debug logs for their request with
+// log.DebugHeader. It is off by default, any client could turn it on.
+func WithDebugHeader() Option {
+	return func(o *options) {
+		o.debug = true
+	}
+}
+
+func defaultRoute(r *http.Request) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+
+	return r.URL.Path
+}
+
+// Middleware returns a middleware handling request ids, panics and access logs.
+func Middleware(l *log.Log, opts ...Option) func(http.Handler) http.Handler {
+	o := &options{newID: uuid.NewString, route: defaultRoute}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestID := r.Header.Get(RequestIDHeader)
+			if requestID == "" || len(requestID) > maxRequestIDLen {
+				requestID = o.newID()
+			}
+			w.Header().Set(RequestIDHeader, requestID)
+
+			ctx := log.ContextWithRequestID(r.Context(), requestID)
+			if o.debug {
+				ctx = log.HTTPDebugContext(ctx, r.Header)
+			}
+			r = r.WithContext(ctx)
+
+			rw := &responseWriter{ResponseWriter: w}
+			defer func() {
+				if p := recover(); p != nil {
+					if p == http.ErrAbortHandler {
+						panic(p)
+					}
+					l.WithCtx(ctx).LogPanic(p)
+					if !rw.wroteHeader {
+						rw.WriteHeader(http.StatusInternalServerError)
+					}
+				}
+
+				logRequest(l, r, rw, o.route(r), time.Since(start))
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// StatusCode maps a log.Code to an HTTP status.
+func StatusCode(code log.Code) int {
+	switch code {
+	case "":
+		return http.StatusOK
+	case log.CodeNotFound:
+		return http.StatusNotFound
+	case log.CodeConflict:
+		return http.StatusConflict
+	case log.CodeInvalid:
+		return http.StatusBadRequest
+	case log.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case log.CodeCanceled:
+		return StatusClientClosedRequest
+	case log.CodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error replies with the HTTP status of the code of err.
+// Messages of 5xx errors are replaced with the status text so internals do not leak.
+func Error(w http.ResponseWriter, err error) {
+	status := StatusCode(log.CodeOf(err))
+	if err == nil || status >= http.StatusInternalServerError {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+func logRequest(l *log.Log, r *http.Request, rw *responseWriter, route string, latency time.Duration) {
+	status := rw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	keysAndValues := []any{
+		"http.method", r.Method,
+		"http.route", route,
+		"http.path", r.URL.Path,
+		"http.status", status,
+		"http.bytes", rw.bytes,
+		"http.latency_ms", float64(latency.Microseconds()) / 1000,
+		"http.remote_addr", r.RemoteAddr,
+		"http.user_agent", r.UserAgent(),
+	}
+
+	logger := l.WithCtx(r.Context())
+	switch {
+	case status >= http.StatusInternalServerError:
+		logger.Errorw("http request", keysAndValues...)
+	case status >= http.StatusBadRequest:
+		logger.Warnw("http request", keysAndValues...)
+	default:
+		logger.Infow("http request", keysAndValues...)
+	}
+}
+
+// responseWriter records the status code and number of bytes written.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+
+	return n, err
+}
+
+// Flush implements http.Flusher when the underlying writer does.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
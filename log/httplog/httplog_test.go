@@ -0,0 +1,223 @@
+package httplog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/D1sordxr/packages/log"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{name: "client error", err: log.NewError(log.CodeInvalid, "bad amount"), status: http.StatusBadRequest, body: "bad amount"},
+		{name: "internal", err: errors.New("pq: connection to postgres://app:pw@db failed"), status: http.StatusInternalServerError, body: "Internal Server Error"},
+		{name: "canceled", err: fmt.Errorf("query: %w", context.Canceled), status: StatusClientClosedRequest, body: "query: context canceled"},
+		{name: "deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), status: http.StatusGatewayTimeout, body: "Gateway Timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Error(rec, tt.err)
+
+			if rec.Code != tt.status || strings.TrimSpace(rec.Body.String()) != tt.body {
+				t.Errorf("reply = %d %q, want %d %q", rec.Code, rec.Body.String(), tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func newTestLog(t *testing.T) (*log.Log, *bytes.Buffer) {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "message", LevelKey: "level", EncodeLevel: zapcore.CapitalLevelEncoder})
+	l := log.NewWithCore(log.Config{LogLevel: "info"}, zapcore.NewCore(enc, zapcore.AddSync(buf), zapcore.DebugLevel))
+	if l == nil {
+		t.Fatal("NewWithCore returned nil")
+	}
+
+	return l, buf
+}
+
+// entries decodes the JSON lines written to buf.
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var result []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		result = append(result, entry)
+	}
+
+	return result
+}
+
+// serve runs r through the middleware and returns the response and the access log entry.
+func serve(t *testing.T, h http.Handler, r *http.Request, opts ...Option) (*httptest.ResponseRecorder, []map[string]any) {
+	t.Helper()
+
+	l, buf := newTestLog(t)
+	rec := httptest.NewRecorder()
+	Middleware(l, append([]Option{WithRequestIDGenerator(func() string { return "generated" })}, opts...)...)(h).ServeHTTP(rec, r)
+
+	return rec, entries(t, buf)
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		want string
+	}{
+		{name: "generated", want: "generated"},
+		{name: "from client", sent: "client-id", want: "client-id"},
+		{name: "too long", sent: strings.Repeat("x", maxRequestIDLen+1), want: "generated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = log.RequestIDFromContext(r.Context())
+			})
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			if tt.sent != "" {
+				r.Header.Set(RequestIDHeader, tt.sent)
+			}
+
+			rec, logged := serve(t, h, r)
+
+			if got != tt.want || rec.Header().Get(RequestIDHeader) != tt.want {
+				t.Errorf("handler id %q, response header %q, want %q", got, rec.Header().Get(RequestIDHeader), tt.want)
+			}
+			if len(logged) != 1 || logged[0][log.RequestIDField] != tt.want {
+				t.Errorf("access log = %v, want request id %q", logged, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAccessLog(t *testing.T) {
+	tests := []struct {
+		status int
+		level  string
+	}{
+		{status: http.StatusOK, level: "INFO"},
+		{status: http.StatusNotFound, level: "WARN"},
+		{status: http.StatusServiceUnavailable, level: "ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte("hello"))
+			})
+			r := httptest.NewRequest(http.MethodGet, "/orders/7", nil)
+			r.Header.Set("User-Agent", "test")
+
+			rec, logged := serve(t, mux, r)
+
+			if rec.Code != tt.status || len(logged) != 1 {
+				t.Fatalf("status %d, access log %v", rec.Code, logged)
+			}
+			entry := logged[0]
+			if entry["level"] != tt.level || entry["message"] != "http request" {
+				t.Errorf("entry = %v, want %s", entry, tt.level)
+			}
+			if entry["http.method"] != "GET" || entry["http.route"] != "GET /orders/{id}" || entry["http.path"] != "/orders/7" ||
+				entry["http.status"] != float64(tt.status) || entry["http.bytes"] != float64(5) ||
+				entry["http.user_agent"] != "test" || entry["http.remote_addr"] != r.RemoteAddr {
+				t.Errorf("entry = %v", entry)
+			}
+			if _, ok := entry["http.latency_ms"].(float64); !ok {
+				t.Errorf("entry has no latency: %v", entry)
+			}
+		})
+	}
+
+	// Handlers that write nothing are logged as 200.
+	_, logged := serve(t, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), httptest.NewRequest(http.MethodGet, "/", nil))
+	if logged[0]["http.status"] != float64(http.StatusOK) || logged[0]["http.route"] != "/" {
+		t.Errorf("entry = %v", logged[0])
+	}
+}
+
+func TestMiddlewareDebugHeader(t *testing.T) {
+	for _, allowed := range []bool{false, true} {
+		var debug bool
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			debug = log.DebugFromContext(r.Context())
+		})
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(log.DebugHeader, "1")
+
+		var opts []Option
+		if allowed {
+			opts = append(opts, WithDebugHeader())
+		}
+		serve(t, h, r, opts...)
+
+		if debug != allowed {
+			t.Errorf("WithDebugHeader %v: debug = %v", allowed, debug)
+		}
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	h := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") })
+
+	rec, logged := serve(t, h, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if len(logged) != 2 {
+		t.Fatalf("got %d entries, want the panic and the access log: %v", len(logged), logged)
+	}
+	if msg, _ := logged[0]["message"].(string); logged[0]["level"] != "ERROR" || !strings.HasPrefix(msg, "Panic recovered: boom") ||
+		logged[0][log.RequestIDField] != "generated" {
+		t.Errorf("panic entry = %v", logged[0])
+	}
+	if logged[1]["http.status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("access log = %v", logged[1])
+	}
+
+	// A panic after the header was sent keeps the status.
+	h = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late")
+	})
+	if rec, _ = serve(t, h, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want 202", rec.Code)
+	}
+}
+
+func TestMiddlewareAbortHandler(t *testing.T) {
+	h := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic(http.ErrAbortHandler) })
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", p)
+		}
+	}()
+	serve(t, h, httptest.NewRequest(http.MethodGet, "/", nil))
+	t.Error("ErrAbortHandler was swallowed")
+}
@@ -0,0 +1,477 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/D1sordxr/packages/kafka/producer"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	kafkaDefaultBatchSize     = 100
+	kafkaDefaultFlushInterval = time.Second
+	kafkaDefaultBufferSize    = 10000
+	kafkaDefaultSpoolMaxSize  = 100 // megabytes
+	kafkaWriteTimeout         = 5 * time.Second
+	kafkaRetryInterval        = 10 * time.Second
+	kafkaProducerBatchTimeout = 10 * time.Millisecond
+	kafkaSpoolReplaySuffix    = ".replay"
+)
+
+// kafkaWriter is the part of kafka.Writer used by the sink.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+type kafkaQueued struct {
+	msg     kafka.Message
+	flushed chan struct{}
+}
+
+// kafkaSpoolRecord is one line of the spool file.
+type kafkaSpoolRecord struct {
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// kafkaSink batches encoded entries and publishes them to a topic. Enqueueing
+// never blocks: entries are dropped when the buffer is full. Batches that
+// cannot be delivered go to the spool file, which is replayed once the broker
+// is reachable again. Without a spool file or when it is full they are dropped.
+// Close stops the sink, entries enqueued afterwards are dropped.
+type kafkaSink struct {
+	writer        kafkaWriter
+	topic         string
+	batchSize     int
+	flushInterval time.Duration
+	spoolPath     string
+	spoolMaxSize  int64
+	retryInterval time.Duration
+	encCfg        zapcore.EncoderConfig
+
+	queue     chan kafkaQueued
+	dropped   atomic.Uint64
+	reported  uint64
+	downUntil time.Time
+
+	spoolMu sync.Mutex
+
+	running    bool
+	unregister func()
+	done       chan struct{}
+	stopped    chan struct{}
+	closeOnce  sync.Once
+}
+
+func newKafkaSink(cfg Config, w kafkaWriter) *kafkaSink {
+	s := &kafkaSink{
+		writer:        w,
+		topic:         cfg.KafkaTopic,
+		batchSize:     cfg.KafkaBatchSize,
+		flushInterval: cfg.KafkaFlushInterval,
+		spoolPath:     cfg.KafkaSpoolPath,
+		spoolMaxSize:  int64(cfg.KafkaSpoolMaxSize) * megabyte,
+		retryInterval: kafkaRetryInterval,
+		encCfg:        encoderConfig(cfg.EncoderKeys),
+		unregister:    func() {},
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = kafkaDefaultBatchSize
+	}
+	if s.flushInterval <= 0 {
+		s.flushInterval = kafkaDefaultFlushInterval
+	}
+	if s.spoolMaxSize <= 0 {
+		s.spoolMaxSize = kafkaDefaultSpoolMaxSize * megabyte
+	}
+	bufferSize := cfg.KafkaBufferSize
+	if bufferSize <= 0 {
+		bufferSize = kafkaDefaultBufferSize
+	}
+	s.queue = make(chan kafkaQueued, bufferSize)
+
+	return s
+}
+
+// start runs the sending goroutine and registers the sink for FlushAll.
+func (s *kafkaSink) start() {
+	s.running = true
+	go s.run()
+	s.unregister = RegisterFlusher(s)
+}
+
+// Close sends or spools the queued entries, stops the sending goroutine,
+// removes the sink from FlushAll and closes the writer.
+func (s *kafkaSink) Close(ctx context.Context) error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.unregister()
+		if s.running {
+			s.Flush(ctx)
+		}
+		close(s.done)
+
+		if s.running {
+			select {
+			case <-s.stopped:
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+		}
+		if c, ok := s.writer.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+
+	return err
+}
+
+// enqueue adds a message without blocking, counting it as dropped when the buffer is full.
+func (s *kafkaSink) enqueue(key string, value []byte) {
+	msg := kafka.Message{Topic: s.topic, Value: value}
+	if key != "" {
+		msg.Key = []byte(key)
+	}
+
+	select {
+	case <-s.done:
+		s.dropped.Add(1)
+		return
+	default:
+	}
+
+	select {
+	case s.queue <- kafkaQueued{msg: msg}:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of entries lost because the buffer or the spool was full.
+func (s *kafkaSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Flush waits until the entries queued so far are sent or spooled.
+func (s *kafkaSink) Flush(ctx context.Context) {
+	flushed := make(chan struct{})
+	select {
+	case s.queue <- kafkaQueued{flushed: flushed}:
+	case <-s.done:
+		return
+	case <-ctx.Done():
+		return
+	}
+
+	select {
+	case <-flushed:
+	case <-s.stopped:
+	case <-ctx.Done():
+	}
+}
+
+func (s *kafkaSink) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]kafka.Message, 0, s.batchSize)
+	for {
+		select {
+		case <-s.done:
+			s.drain(batch)
+			return
+		case q := <-s.queue:
+			if q.flushed != nil {
+				s.send(batch)
+				batch = batch[:0]
+				close(q.flushed)
+				continue
+			}
+			batch = append(batch, q.msg)
+			if len(batch) >= s.batchSize {
+				s.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			s.send(batch)
+			batch = batch[:0]
+			s.replaySpool()
+		}
+	}
+}
+
+// drain sends batch and the entries still queued when the sink is closed.
+func (s *kafkaSink) drain(batch []kafka.Message) {
+	for {
+		select {
+		case q := <-s.queue:
+			if q.flushed != nil {
+				close(q.flushed)
+				continue
+			}
+			batch = append(batch, q.msg)
+		default:
+			s.send(batch)
+			return
+		}
+	}
+}
+
+// send publishes batch, spooling it when the broker is unreachable.
+func (s *kafkaSink) send(batch []kafka.Message) {
+	if report, ok := s.dropReport(); ok {
+		batch = append(batch, report)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	if time.Now().Before(s.downUntil) {
+		s.spool(batch)
+		return
+	}
+	if err := s.write(batch); err != nil {
+		s.downUntil = time.Now().Add(s.retryInterval)
+		s.spool(batch)
+	}
+}
+
+func (s *kafkaSink) write(batch []kafka.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaWriteTimeout)
+	defer cancel()
+
+	return s.writer.WriteMessages(ctx, batch...)
+}
+
+// dropReport returns an entry counting the drops since the last report.
+func (s *kafkaSink) dropReport() (kafka.Message, bool) {
+	total := s.dropped.Load()
+	if total <= s.reported {
+		return kafka.Message{}, false
+	}
+
+	entry := map[string]any{"dropped": total - s.reported, "dropped_total": total}
+	if s.encCfg.MessageKey != zapcore.OmitKey {
+		entry[s.encCfg.MessageKey] = "kafka log sink dropped entries"
+	}
+	if s.encCfg.LevelKey != zapcore.OmitKey {
+		entry[s.encCfg.LevelKey] = zapcore.WarnLevel.CapitalString()
+	}
+	if s.encCfg.TimeKey != zapcore.OmitKey {
+		entry[s.encCfg.TimeKey] = time.Now().Format("2006-01-02T15:04:05.000Z0700")
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return kafka.Message{}, false
+	}
+	s.reported = total
+
+	return kafka.Message{Topic: s.topic, Value: value}, true
+}
+
+// spool appends batch to the spool file, dropping what does not fit.
+func (s *kafkaSink) spool(batch []kafka.Message) {
+	if s.spoolPath == "" {
+		s.dropped.Add(uint64(len(batch)))
+		return
+	}
+
+	s.spoolMu.Lock()
+	defer s.spoolMu.Unlock()
+
+	f, err := os.OpenFile(s.spoolPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		s.dropped.Add(uint64(len(batch)))
+		return
+	}
+	defer f.Close()
+
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	w := bufio.NewWriter(f)
+	for i, msg := range batch {
+		line, err := json.Marshal(kafkaSpoolRecord{Key: string(msg.Key), Value: msg.Value})
+		if err != nil || size+int64(len(line))+1 > s.spoolMaxSize {
+			s.dropped.Add(uint64(len(batch) - i))
+			break
+		}
+		_, _ = w.Write(append(line, '\n'))
+		size += int64(len(line)) + 1
+	}
+	_ = w.Flush()
+}
+
+// replaySpool sends spooled entries once the broker is reachable again.
+// Entries that still cannot be sent are spooled again.
+func (s *kafkaSink) replaySpool() {
+	if s.spoolPath == "" || time.Now().Before(s.downUntil) {
+		return
+	}
+
+	replayPath := s.spoolPath + kafkaSpoolReplaySuffix
+	s.spoolMu.Lock()
+	if _, err := os.Stat(replayPath); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(s.spoolPath, replayPath); err != nil {
+			s.spoolMu.Unlock()
+			return
+		}
+	}
+	s.spoolMu.Unlock()
+
+	f, err := os.Open(replayPath)
+	if err != nil {
+		return
+	}
+	defer os.Remove(replayPath)
+	defer f.Close()
+
+	batch := make([]kafka.Message, 0, s.batchSize)
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		if time.Now().Before(s.downUntil) || s.write(batch) != nil {
+			s.downUntil = time.Now().Add(s.retryInterval)
+			s.spool(batch)
+			return false
+		}
+		return true
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(s.spoolMaxSize))
+	for scanner.Scan() {
+		var rec kafkaSpoolRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		msg := kafka.Message{Topic: s.topic, Value: append([]byte(nil), rec.Value...)}
+		if rec.Key != "" {
+			msg.Key = []byte(rec.Key)
+		}
+		batch = append(batch, msg)
+		if len(batch) >= s.batchSize {
+			flush()
+			batch = batch[:0]
+		}
+	}
+	flush()
+}
+
+// kafkaCore encodes entries as JSON and hands them to the sink,
+// keyed by the request id so the lines of a request share a partition.
+// Like zap's ioCore it syncs above Error, so Panic and Fatal entries are sent
+// before the process goes down.
+type kafkaCore struct {
+	zapcore.LevelEnabler
+	enc       zapcore.Encoder
+	sink      *kafkaSink
+	requestID string
+}
+
+func newKafkaCore(cfg Config) (*kafkaCore, *kafkaSink, error) {
+	level := zapcore.DebugLevel
+	if cfg.KafkaLevel != "" {
+		var err error
+		if level, err = zapcore.ParseLevel(cfg.KafkaLevel); err != nil {
+			return nil, nil, fmt.Errorf("invalid kafka level: %w", err)
+		}
+	}
+
+	p := producer.NewProducer(&producer.Config{
+		Brokers:      cfg.KafkaBrokers,
+		BatchSize:    cfg.KafkaBatchSize,
+		BatchTimeout: kafkaProducerBatchTimeout,
+	})
+	p.Writer.WriteTimeout = kafkaWriteTimeout
+	p.Writer.MaxAttempts = 1
+	// The request id is the message key, hashing it keeps the lines of a request in one partition.
+	p.Writer.Balancer = &kafka.Hash{}
+	sink := newKafkaSink(cfg, p.Writer)
+	sink.start()
+
+	return &kafkaCore{
+		LevelEnabler: level,
+		enc:          zapcore.NewJSONEncoder(encoderConfig(cfg.EncoderKeys)),
+		sink:         sink,
+	}, sink, nil
+}
+
+func (c *kafkaCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+		if f.Key == RequestIDField && f.Type == zapcore.StringType {
+			clone.requestID = f.String
+		}
+	}
+
+	return &clone
+}
+
+func (c *kafkaCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *kafkaCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	requestID := c.requestID
+	for _, f := range fields {
+		if f.Key == RequestIDField && f.Type == zapcore.StringType {
+			requestID = f.String
+		}
+	}
+
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	value := make([]byte, len(buf.Bytes()))
+	copy(value, buf.Bytes())
+	buf.Free()
+
+	c.sink.enqueue(requestID, trimNewline(value))
+	if ent.Level > zapcore.ErrorLevel {
+		return c.Sync()
+	}
+
+	return nil
+}
+
+func (c *kafkaCore) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultAsyncFlushTimeout)
+	defer cancel()
+
+	c.sink.Flush(ctx)
+
+	return nil
+}
+
+func trimNewline(b []byte) []byte {
+	for len(b) > 0 && (b[len(b)-1] == '\n' || b[len(b)-1] == '\r') {
+		b = b[:len(b)-1]
+	}
+
+	return b
+}
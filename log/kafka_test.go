@@ -0,0 +1,336 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKafkaWriter records written messages and fails while down is set.
+type fakeKafkaWriter struct {
+	mu     sync.Mutex
+	down   bool
+	closed bool
+	msgs   []kafka.Message
+}
+
+func (w *fakeKafkaWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.down {
+		return errors.New("broker unreachable")
+	}
+	w.msgs = append(w.msgs, msgs...)
+
+	return nil
+}
+
+func (w *fakeKafkaWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	return nil
+}
+
+func (w *fakeKafkaWriter) setDown(down bool) {
+	w.mu.Lock()
+	w.down = down
+	w.mu.Unlock()
+}
+
+func (w *fakeKafkaWriter) written() []kafka.Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]kafka.Message(nil), w.msgs...)
+}
+
+func newTestSink(t *testing.T, cfg Config, w kafkaWriter) *kafkaSink {
+	t.Helper()
+
+	cfg.KafkaTopic = "logs"
+	if cfg.KafkaFlushInterval == 0 {
+		cfg.KafkaFlushInterval = 10 * time.Millisecond
+	}
+	s := newKafkaSink(cfg, w)
+	s.retryInterval = 20 * time.Millisecond
+	t.Cleanup(func() { _ = s.Close(context.Background()) })
+
+	return s
+}
+
+func flushSink(t *testing.T, s *kafkaSink) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Flush(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("flush timed out")
+	}
+}
+
+func spooled(t *testing.T, path string) []kafkaSpoolRecord {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []kafkaSpoolRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec kafkaSpoolRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+func TestKafkaSinkSpoolsAndReplays(t *testing.T) {
+	w := &fakeKafkaWriter{down: true}
+	spool := filepath.Join(t.TempDir(), "kafka.spool")
+	s := newTestSink(t, Config{KafkaSpoolPath: spool}, w)
+	s.start()
+
+	s.enqueue("req-1", []byte(`{"message":"a"}`))
+	s.enqueue("req-2", []byte(`{"message":"b"}`))
+	s.enqueue("", []byte(`{"message":"c"}`))
+	flushSink(t, s)
+
+	records := spooled(t, spool)
+	if len(records) != 3 || records[0].Key != "req-1" || string(records[2].Value) != `{"message":"c"}` {
+		t.Fatalf("spool = %+v", records)
+	}
+	if s.Dropped() != 0 {
+		t.Errorf("dropped %d entries, spooled ones must not count", s.Dropped())
+	}
+
+	w.setDown(false)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(w.written()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("spool was not replayed, written %d", len(w.written()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	msgs := w.written()
+	if string(msgs[0].Key) != "req-1" || string(msgs[1].Key) != "req-2" || msgs[2].Key != nil {
+		t.Errorf("replayed keys = %q, %q, %q", msgs[0].Key, msgs[1].Key, msgs[2].Key)
+	}
+	for _, msg := range msgs {
+		if msg.Topic != "logs" {
+			t.Errorf("topic = %q", msg.Topic)
+		}
+	}
+	flushSink(t, s)
+	for _, path := range []string{spool, spool + kafkaSpoolReplaySuffix} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left behind: %v", path, err)
+		}
+	}
+}
+
+func TestKafkaSinkDropsWhenBufferIsFull(t *testing.T) {
+	w := &fakeKafkaWriter{}
+	s := newTestSink(t, Config{KafkaBufferSize: 2}, w)
+
+	// Nothing consumes the queue before start.
+	for i := 0; i < 5; i++ {
+		s.enqueue("", []byte(`{}`))
+	}
+	if s.Dropped() != 3 {
+		t.Fatalf("dropped %d, want 3", s.Dropped())
+	}
+
+	s.start()
+	flushSink(t, s)
+
+	msgs := w.written()
+	if len(msgs) != 3 {
+		t.Fatalf("written %d messages, want 2 entries and a drop report", len(msgs))
+	}
+	var report map[string]any
+	if err := json.Unmarshal(msgs[2].Value, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report["dropped"] != float64(3) || report["dropped_total"] != float64(3) {
+		t.Errorf("drop report = %v", report)
+	}
+
+	// The drops are reported once.
+	s.enqueue("", []byte(`{}`))
+	flushSink(t, s)
+	if n := len(w.written()); n != 4 {
+		t.Errorf("written %d messages, want 4", n)
+	}
+}
+
+func TestKafkaSinkDropsWithoutSpool(t *testing.T) {
+	w := &fakeKafkaWriter{down: true}
+	s := newTestSink(t, Config{}, w)
+	s.start()
+
+	s.enqueue("", []byte(`{}`))
+	s.enqueue("", []byte(`{}`))
+	flushSink(t, s)
+
+	if s.Dropped() != 2 {
+		t.Errorf("dropped %d, want 2", s.Dropped())
+	}
+}
+
+func TestKafkaSinkSpoolLimit(t *testing.T) {
+	w := &fakeKafkaWriter{down: true}
+	spool := filepath.Join(t.TempDir(), "kafka.spool")
+	s := newTestSink(t, Config{KafkaSpoolPath: spool}, w)
+	line, _ := json.Marshal(kafkaSpoolRecord{Value: json.RawMessage(`{"message":"x"}`)})
+	s.spoolMaxSize = int64(2 * (len(line) + 1))
+	s.start()
+
+	for i := 0; i < 5; i++ {
+		s.enqueue("", []byte(`{"message":"x"}`))
+	}
+	flushSink(t, s)
+
+	if n := len(spooled(t, spool)); n != 2 {
+		t.Errorf("spooled %d entries, want 2", n)
+	}
+	if s.Dropped() != 3 {
+		t.Errorf("dropped %d, want 3", s.Dropped())
+	}
+}
+
+func TestKafkaCoreKeysByRequestID(t *testing.T) {
+	s := newTestSink(t, Config{}, &fakeKafkaWriter{})
+	core := &kafkaCore{LevelEnabler: zapcore.InfoLevel, enc: zapcore.NewJSONEncoder(defaultEncoderConfig()), sink: s}
+
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Message: "m"}
+	if err := core.With([]zapcore.Field{zap.String(RequestIDField, "req-1")}).Write(ent, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Write(ent, []zapcore.Field{zap.String(RequestIDField, "req-2")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Write(ent, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"req-1", "req-2", ""} {
+		q := <-s.queue
+		if string(q.msg.Key) != want {
+			t.Errorf("key = %q, want %q", q.msg.Key, want)
+		}
+	}
+}
+
+func TestKafkaCoreHashesKeys(t *testing.T) {
+	_, sink, err := newKafkaCore(Config{KafkaBrokers: []string{"127.0.0.1:9092"}, KafkaTopic: "logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close(context.Background())
+
+	writer := sink.writer.(*kafka.Writer)
+	balancer, ok := writer.Balancer.(*kafka.Hash)
+	if !ok {
+		t.Fatalf("balancer = %T, want *kafka.Hash", writer.Balancer)
+	}
+	partitions := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	first := balancer.Balance(kafka.Message{Key: []byte("req-1")}, partitions...)
+	for i := 0; i < 10; i++ {
+		if p := balancer.Balance(kafka.Message{Key: []byte("req-1")}, partitions...); p != first {
+			t.Fatalf("partition %d, then %d for the same request id", first, p)
+		}
+	}
+}
+
+func TestKafkaCoreSyncsAboveError(t *testing.T) {
+	w := &fakeKafkaWriter{}
+	s := newTestSink(t, Config{KafkaFlushInterval: time.Hour}, w)
+	s.start()
+	core := &kafkaCore{LevelEnabler: zapcore.DebugLevel, enc: zapcore.NewJSONEncoder(defaultEncoderConfig()), sink: s}
+
+	if err := core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "queued"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(w.written()); n != 0 {
+		t.Fatalf("written %d messages before the flush interval", n)
+	}
+
+	for _, lvl := range []zapcore.Level{zapcore.DPanicLevel, zapcore.PanicLevel, zapcore.FatalLevel} {
+		before := len(w.written())
+		if err := core.Write(zapcore.Entry{Level: lvl, Message: "crash"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if msgs := w.written(); len(msgs) <= before || !strings.Contains(string(msgs[len(msgs)-1].Value), "crash") {
+			t.Errorf("%s entry was not sent by Write", lvl)
+		}
+	}
+}
+
+func TestKafkaSinkClose(t *testing.T) {
+	w := &fakeKafkaWriter{}
+	s := newTestSink(t, Config{KafkaFlushInterval: time.Hour}, w)
+	s.start()
+
+	s.enqueue("", []byte(`{"message":"a"}`))
+	s.enqueue("", []byte(`{"message":"b"}`))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(w.written()); n != 2 {
+		t.Errorf("written %d messages, want the queued 2", n)
+	}
+	select {
+	case <-s.stopped:
+	default:
+		t.Error("sender goroutine still running")
+	}
+	if !w.closed {
+		t.Error("writer was not closed")
+	}
+	flushersMu.Lock()
+	_, registered := flushers[s]
+	flushersMu.Unlock()
+	if registered {
+		t.Error("sink is still registered for FlushAll")
+	}
+
+	// Entries after Close are dropped and Flush does not wait.
+	s.enqueue("", []byte(`{}`))
+	if s.Dropped() != 1 {
+		t.Errorf("dropped %d, want 1", s.Dropped())
+	}
+	s.Flush(ctx)
+	if ctx.Err() != nil {
+		t.Error("Flush after Close waited for the deadline")
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
@@ -0,0 +1,327 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/metadata"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// DebugHeader enables debug logs for a single HTTP request.
+	DebugHeader = "X-Debug"
+	// DebugMetadataKey enables debug logs for a single gRPC call.
+	DebugMetadataKey = "x-debug"
+)
+
+// namedLevels holds level overrides by logger name prefix, shared by a Log and its children.
+type namedLevels struct {
+	mu     sync.RWMutex
+	levels map[string]zapcore.Level
+}
+
+func newNamedLevels(cfg map[string]string) (*namedLevels, error) {
+	n := &namedLevels{levels: make(map[string]zapcore.Level, len(cfg))}
+	for name, text := range cfg {
+		lvl, err := zapcore.ParseLevel(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level for %q: %w", name, err)
+		}
+		n.levels[name] = lvl
+	}
+
+	return n, nil
+}
+
+// lookup returns the override of the longest dot-separated prefix of name,
+// so "kafka" applies to "kafka.consumer" but not to "kafkaesque".
+func (n *namedLevels) lookup(name string) (zapcore.Level, bool) {
+	if name == "" {
+		return 0, false
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if len(n.levels) == 0 {
+		return 0, false
+	}
+	for {
+		if lvl, ok := n.levels[name]; ok {
+			return lvl, true
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			return 0, false
+		}
+		name = name[:i]
+	}
+}
+
+func (n *namedLevels) set(name string, lvl zapcore.Level) {
+	n.mu.Lock()
+	n.levels[name] = lvl
+	n.mu.Unlock()
+}
+
+func (n *namedLevels) unset(name string) {
+	n.mu.Lock()
+	delete(n.levels, name)
+	n.mu.Unlock()
+}
+
+func (n *namedLevels) snapshot() map[string]zapcore.Level {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	levels := make(map[string]zapcore.Level, len(n.levels))
+	for name, lvl := range n.levels {
+		levels[name] = lvl
+	}
+
+	return levels
+}
+
+// levelCore filters entries by a shared AtomicLevel, or by the override for its logger name.
+// A forced core lets every entry through, which is how per-request debug works.
+type levelCore struct {
+	zapcore.Core
+	level zap.AtomicLevel
+	names *namedLevels
+	name  string
+	force bool
+}
+
+func newLevelCore(core zapcore.Core, level zap.AtomicLevel, names *namedLevels) *levelCore {
+	return &levelCore{Core: core, level: level, names: names}
+}
+
+func (c *levelCore) enabled(lvl zapcore.Level) bool {
+	if c.force {
+		return true
+	}
+	if override, ok := c.names.lookup(c.name); ok {
+		return lvl >= override
+	}
+
+	return c.level.Enabled(lvl)
+}
+
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return c.enabled(lvl) && c.Core.Enabled(lvl)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+
+	return &clone
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabled(ent.Level) {
+		return ce
+	}
+
+	return c.Core.Check(ent, ce)
+}
+
+// forceDebug returns a zap option that lets debug entries bypass the level.
+func forceDebug() zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return updateLevelCore(core, func(c *levelCore) { c.force = true })
+	})
+}
+
+// withName returns a zap option applying the level overrides of name.
+func withName(name string) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return updateLevelCore(core, func(c *levelCore) { c.name = name })
+	})
+}
+
+// updateLevelCore returns core with a modified copy of its levelCore.
+func updateLevelCore(core zapcore.Core, update func(c *levelCore)) zapcore.Core {
+	switch c := core.(type) {
+	case *levelCore:
+		clone := *c
+		update(&clone)
+		return &clone
+	case *spanCore:
+		clone := *c
+		clone.Core = updateLevelCore(c.Core, update)
+		return &clone
+	default:
+		return core
+	}
+}
+
+// Named returns a child logger whose name is l's name and name joined with a dot.
+// Level overrides from Config.Levels and SetNamedLevel apply to it by name prefix.
+func (l *Log) Named(name string) *Log {
+	copied := l.copyWithEntry(*l.logger.Named(name))
+	copied.logger = copied.logger.WithOptions(withName(copied.loggerStd.Name()))
+	copied.loggerStd = copied.logger.Desugar()
+
+	return copied
+}
+
+// Level returns the current minimal level.
+func (l *Log) Level() zapcore.Level {
+	return l.level.Level()
+}
+
+// SetLevel changes the minimal level of l and of every logger derived from it.
+func (l *Log) SetLevel(lvl zapcore.Level) {
+	l.level.SetLevel(lvl)
+}
+
+// SetNamedLevel overrides the level of loggers named name or starting with name and a dot.
+func (l *Log) SetNamedLevel(name string, lvl zapcore.Level) {
+	l.levels.set(name, lvl)
+}
+
+// UnsetNamedLevel removes the override for name, its loggers follow the global level again.
+func (l *Log) UnsetNamedLevel(name string) {
+	l.levels.unset(name)
+}
+
+// NamedLevels returns the current level overrides by name prefix.
+func (l *Log) NamedLevels() map[string]zapcore.Level {
+	return l.levels.snapshot()
+}
+
+// LevelHandler returns an http.Handler reporting the levels on GET and changing them on PUT,
+// e.g. curl -X PUT -d '{"level":"info","levels":{"kafka.consumer":"debug"}}' host/log/level.
+// An empty level in "levels" removes the override. Form requests accept level and an optional name.
+func (l *Log) LevelHandler() http.Handler {
+	return levelHandler{log: l}
+}
+
+type levelPayload struct {
+	Level  *zapcore.Level    `json:"level,omitempty"`
+	Levels map[string]string `json:"levels,omitempty"`
+}
+
+type levelHandler struct {
+	log *Log
+}
+
+func (h levelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if err := h.update(r); err != nil {
+			h.reply(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	default:
+		h.reply(w, http.StatusMethodNotAllowed, map[string]string{"error": "only GET and PUT are supported"})
+		return
+	}
+
+	levels := make(map[string]string)
+	for name, lvl := range h.log.NamedLevels() {
+		levels[name] = lvl.String()
+	}
+	lvl := h.log.Level()
+	h.reply(w, http.StatusOK, levelPayload{Level: &lvl, Levels: levels})
+}
+
+func (h levelHandler) update(r *http.Request) error {
+	// Clients may add parameters such as charset to the media type.
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		var lvl zapcore.Level
+		if err := lvl.UnmarshalText([]byte(r.FormValue("level"))); err != nil {
+			return err
+		}
+		if name := r.FormValue("name"); name != "" {
+			h.log.SetNamedLevel(name, lvl)
+		} else {
+			h.log.SetLevel(lvl)
+		}
+		return nil
+	}
+
+	var payload levelPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("malformed request body: %w", err)
+	}
+	if payload.Level == nil && len(payload.Levels) == 0 {
+		return errors.New("level or levels must be specified")
+	}
+
+	// Validate everything before applying, so a bad entry changes nothing.
+	levels := make(map[string]*zapcore.Level, len(payload.Levels))
+	for name, text := range payload.Levels {
+		if text == "" {
+			levels[name] = nil
+			continue
+		}
+		lvl, err := zapcore.ParseLevel(text)
+		if err != nil {
+			return fmt.Errorf("invalid level for %q: %w", name, err)
+		}
+		levels[name] = &lvl
+	}
+
+	if payload.Level != nil {
+		h.log.SetLevel(*payload.Level)
+	}
+	for name, lvl := range levels {
+		if lvl == nil {
+			h.log.UnsetNamedLevel(name)
+			continue
+		}
+		h.log.SetNamedLevel(name, *lvl)
+	}
+
+	return nil
+}
+
+func (h levelHandler) reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// DebugRequested reports whether v asks for debug logs.
+func DebugRequested(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "on", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// HTTPDebugContext enables debug logs for ctx when the request carries DebugHeader.
+func HTTPDebugContext(ctx context.Context, h http.Header) context.Context {
+	if !DebugRequested(h.Get(DebugHeader)) {
+		return ctx
+	}
+
+	return ContextWithDebug(ctx)
+}
+
+// GRPCDebugContext enables debug logs for ctx when incoming metadata carries DebugMetadataKey.
+func GRPCDebugContext(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	for _, v := range md.Get(DebugMetadataKey) {
+		if DebugRequested(v) {
+			return ContextWithDebug(ctx)
+		}
+	}
+
+	return ctx
+}
@@ -0,0 +1,228 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveLevel sends a request to the LevelHandler of l and decodes the reply.
+func serveLevel(t *testing.T, l *Log, method, contentType, body string) (int, map[string]any) {
+	t.Helper()
+
+	r := httptest.NewRequest(method, "/log/level", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	l.LevelHandler().ServeHTTP(rec, r)
+
+	var reply map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+
+	return rec.Code, reply
+}
+
+func TestLevelHandlerGet(t *testing.T) {
+	l, _ := newBufferLog(t, Config{LogLevel: "warn", Levels: map[string]string{"kafka": "debug"}})
+
+	code, reply := serveLevel(t, l, http.MethodGet, "", "")
+
+	levels, _ := reply["levels"].(map[string]any)
+	if code != http.StatusOK || reply["level"] != "warn" || levels["kafka"] != "debug" {
+		t.Errorf("GET = %d %v", code, reply)
+	}
+}
+
+func TestLevelHandlerPut(t *testing.T) {
+	l, _ := newBufferLog(t, Config{LogLevel: "info", Levels: map[string]string{"kafka": "debug"}})
+
+	code, reply := serveLevel(t, l, http.MethodPut, "application/json", `{"level":"error","levels":{"db":"warn","kafka":""}}`)
+
+	if code != http.StatusOK || reply["level"] != "error" {
+		t.Errorf("PUT = %d %v", code, reply)
+	}
+	if l.Level() != zapcore.ErrorLevel {
+		t.Errorf("level = %s, want error", l.Level())
+	}
+	if got := l.NamedLevels(); len(got) != 1 || got["db"] != zapcore.WarnLevel {
+		t.Errorf("named levels = %v, want only db at warn", got)
+	}
+}
+
+func TestLevelHandlerPutForm(t *testing.T) {
+	l, _ := newBufferLog(t, Config{LogLevel: "info"})
+
+	for _, contentType := range []string{"application/x-www-form-urlencoded", "application/x-www-form-urlencoded; charset=UTF-8"} {
+		if code, reply := serveLevel(t, l, http.MethodPut, contentType, "level=debug&name=db"); code != http.StatusOK {
+			t.Errorf("PUT %s = %d %v", contentType, code, reply)
+		}
+	}
+	if code, reply := serveLevel(t, l, http.MethodPut, "Application/X-WWW-Form-Urlencoded", "level=warn"); code != http.StatusOK {
+		t.Errorf("PUT = %d %v", code, reply)
+	}
+
+	if l.Level() != zapcore.WarnLevel || l.NamedLevels()["db"] != zapcore.DebugLevel {
+		t.Errorf("level = %s, named levels = %v", l.Level(), l.NamedLevels())
+	}
+}
+
+func TestLevelHandlerRejects(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		code        int
+	}{
+		{name: "method", method: http.MethodPost, body: `{"level":"debug"}`, code: http.StatusMethodNotAllowed},
+		{name: "malformed", method: http.MethodPut, body: `{"level":`, code: http.StatusBadRequest},
+		{name: "empty", method: http.MethodPut, body: `{}`, code: http.StatusBadRequest},
+		{name: "bad level", method: http.MethodPut, body: `{"level":"loud"}`, code: http.StatusBadRequest},
+		{name: "bad named level", method: http.MethodPut, body: `{"level":"debug","levels":{"db":"loud"}}`, code: http.StatusBadRequest},
+		{name: "bad form level", method: http.MethodPut, contentType: "application/x-www-form-urlencoded", body: "level=loud", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newBufferLog(t, Config{LogLevel: "info"})
+
+			code, reply := serveLevel(t, l, tt.method, tt.contentType, tt.body)
+
+			if code != tt.code || reply["error"] == nil {
+				t.Errorf("%s = %d %v, want %d with an error", tt.method, code, reply, tt.code)
+			}
+			// A rejected request changes nothing.
+			if l.Level() != zapcore.InfoLevel || len(l.NamedLevels()) != 0 {
+				t.Errorf("level = %s, named levels = %v", l.Level(), l.NamedLevels())
+			}
+		})
+	}
+}
+
+func TestHTTPDebugContext(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "0": false, "1": true, " TRUE ": true, "yes": true, "on": true} {
+		h := http.Header{}
+		if value != "" {
+			h.Set(DebugHeader, value)
+		}
+		if got := DebugFromContext(HTTPDebugContext(context.Background(), h)); got != want {
+			t.Errorf("%s %q: debug = %v, want %v", DebugHeader, value, got, want)
+		}
+	}
+}
+
+func TestGRPCDebugContext(t *testing.T) {
+	if DebugFromContext(GRPCDebugContext(context.Background())) {
+		t.Error("debug without metadata")
+	}
+	for value, want := range map[string]bool{"false": false, "1": true, "true": true} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(DebugMetadataKey, value))
+		if got := DebugFromContext(GRPCDebugContext(ctx)); got != want {
+			t.Errorf("%s %q: debug = %v, want %v", DebugMetadataKey, value, got, want)
+		}
+	}
+}
+
+func TestDebugLevelWritesDebugEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "debug.log")
+	l := New(Config{LogLevel: "debug", OutputPaths: []string{path}})
+	if l == nil {
+		t.Fatal("New returned nil")
+	}
+	t.Cleanup(func() {
+		filesMu.Lock()
+		f := files[path]
+		delete(files, path)
+		filesMu.Unlock()
+		_ = f.file.Close()
+	})
+
+	l.Debug("plain")
+	l.Debugf("formatted %d", 1)
+	l.Named("db").Debugw("structured", "n", 2)
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{`"message":"plain"`, `"message":"formatted 1"`, `"message":"structured"`} {
+		if !strings.Contains(string(data), msg) {
+			t.Errorf("%s missing from %s", msg, data)
+		}
+	}
+}
+
+func TestNamedLevelPrefix(t *testing.T) {
+	l, buf := newBufferLog(t, Config{LogLevel: "info", Levels: map[string]string{"kafka": "debug", "kafka.producer": "error"}})
+
+	for _, name := range []string{"kafka", "kafka.consumer", "kafka.consumer.group", "kafkaesque", "kafka.producer", "kafka.producer.batch", "db"} {
+		l.Named(name).Debug("debug")
+		l.Named(name).Warn("warn")
+	}
+
+	var got []string
+	for _, entry := range entries(t, buf) {
+		got = append(got, entry["logger"].(string)+" "+entry["message"].(string))
+	}
+	want := "kafka debug,kafka warn,kafka.consumer debug,kafka.consumer warn,kafka.consumer.group debug,kafka.consumer.group warn," +
+		"kafkaesque warn,db warn"
+	if strings.Join(got, ",") != want {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+}
+
+func TestNamedLevelChangedAtRuntime(t *testing.T) {
+	l, buf := newBufferLog(t, Config{LogLevel: "info"})
+	consumer := l.Named("kafka").Named("consumer")
+
+	consumer.Debug("before")
+	if code, reply := serveLevel(t, l, http.MethodPut, "application/json", `{"levels":{"kafka":"debug"}}`); code != http.StatusOK {
+		t.Fatalf("PUT = %d %v", code, reply)
+	}
+	consumer.Debug("overridden")
+	l.Debug("root")
+	if code, reply := serveLevel(t, l, http.MethodPut, "application/json", `{"levels":{"kafka":""}}`); code != http.StatusOK {
+		t.Fatalf("PUT = %d %v", code, reply)
+	}
+	consumer.Debug("removed")
+
+	if got := entries(t, buf); len(got) != 1 || got[0]["message"] != "overridden" {
+		t.Errorf("entries = %v, want only the one logged with the override", got)
+	}
+}
+
+func TestNamedLevelWithContext(t *testing.T) {
+	l, buf := newBufferLog(t, Config{LogLevel: "debug", Levels: map[string]string{"db": "error"}})
+	db := l.Named("db")
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+
+	// WithCtx keeps the override of the name.
+	db.WithCtx(ctx).Warn("filtered")
+	db.WithCtx(ctx).Error("kept")
+	// A debug context lets every entry through, the override included.
+	db.WithCtx(ContextWithDebug(ctx)).Debug("forced")
+	// Naming a debug logger keeps it forced.
+	l.WithCtx(ContextWithDebug(ctx)).Named("db").Debug("forced child")
+
+	got := entries(t, buf)
+	if len(got) != 3 {
+		t.Fatalf("got %d entries, want 3: %v", len(got), got)
+	}
+	for i, msg := range []string{"kept", "forced", "forced child"} {
+		if got[i]["message"] != msg || got[i]["logger"] != "db" || got[i][RequestIDField] != "req-1" {
+			t.Errorf("entry %d = %v, want %q", i, got[i], msg)
+		}
+	}
+}
@@ -2,14 +2,14 @@ package log
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"os"
-	"os/signal"
 	"runtime/debug"
-	"syscall"
+	"time"
 )
 
 const (
@@ -26,10 +26,19 @@ type Logger interface {
 	Debug(msg string)
 	Debugf(msg string, args ...interface{})
 	Debugw(msg string, keysAndValues ...interface{})
+	Warn(msg string)
+	Warnf(msg string, args ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
 	Error(msg string)
 	Errorf(msg string, args ...interface{})
 	Errorw(msg string, keysAndValues ...interface{})
 	Panic(msg string)
+	Fatal(msg string)
+	Fatalf(msg string, args ...interface{})
+	Fatalw(msg string, keysAndValues ...interface{})
+	ErrWithWarn(ctx context.Context, err error, msg string)
+	ErrWithWarnf(ctx context.Context, err error, msg string, args ...interface{})
+	ErrWithWarnw(ctx context.Context, err error, msg string, keysAndValues ...interface{})
 	ErrWithError(ctx context.Context, err error, msg string)
 	ErrWithErrorf(ctx context.Context, err error, msg string, args ...interface{})
 	ErrWithErrorw(ctx context.Context, err error, msg string, keysAndValues ...interface{})
@@ -37,27 +46,29 @@ type Logger interface {
 	LogGRPC(ctx context.Context, lvl logging.Level, msg string, fields ...any)
 }
 
-var (
-	glog = Default()
-)
-
-func init() {
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGHUP)
-
-	go func() {
-		for {
-			<-signals
-			glog.Info("Log Rotate signal received")
-		}
-	}()
-}
+var _ Logger = (*Log)(nil)
 
 type Config struct {
-	LogLevel         string
-	ContextLogFields []string `mapstructure:"context_log_fields"`
+	LogLevel string
+	// Levels overrides LogLevel for loggers created with Named, keyed by name prefix,
+	// e.g. {"kafka.consumer": "debug"}.
+	Levels           map[string]string `mapstructure:"levels"`
+	ContextLogFields []string          `mapstructure:"context_log_fields"`
 	CallerSkip       int
 
+	// OutputPaths lists "stderr", "stdout" or file paths written as JSON.
+	// Files are reopened on SIGHUP once InstallSignalHandlers is called.
+	OutputPaths []string `mapstructure:"output_paths"`
+	// Outputs add destinations with their own encoding and level.
+	Outputs     []OutputConfig `mapstructure:"outputs"`
+	EncoderKeys EncoderKeys    `mapstructure:"encoder_keys"`
+	FileMaxSize int            `mapstructure:"file_max_size"` // megabytes, 0 disables size rotation
+	// FileMaxAge, FileMaxBackups and FileCompress apply to the backups made by
+	// size rotation, so they need FileMaxSize. Files are not rotated by age.
+	FileMaxAge     time.Duration `mapstructure:"file_max_age"` // backups older than this are removed
+	FileMaxBackups int           `mapstructure:"file_max_backups"`
+	FileCompress   bool          `mapstructure:"file_compress"`
+
 	SentryDSN               string `mapstructure:"sentry_dsn"`
 	SentryEnableBreadcrumbs bool
 	SentryMaxBreadcrumbs    int
@@ -66,61 +77,198 @@ type Config struct {
 	TgToken        string
 	TgMsgParseMode string
 	TgAppName      string
+	TgAPIURL       string        `mapstructure:"tg_api_url"`
+	TgSendInterval time.Duration `mapstructure:"tg_send_interval"`
+	TgDedupWindow  time.Duration `mapstructure:"tg_dedup_window"`
+
+	// Kafka ships entries to KafkaTopic when brokers and a topic are set.
+	// Undeliverable batches go to KafkaSpoolPath, if set, and are replayed later.
+	KafkaBrokers       []string      `mapstructure:"kafka_brokers"`
+	KafkaTopic         string        `mapstructure:"kafka_topic"`
+	KafkaLevel         string        `mapstructure:"kafka_level"`
+	KafkaBatchSize     int           `mapstructure:"kafka_batch_size"`
+	KafkaFlushInterval time.Duration `mapstructure:"kafka_flush_interval"`
+	KafkaBufferSize    int           `mapstructure:"kafka_buffer_size"` // entries held in memory
+	KafkaSpoolPath     string        `mapstructure:"kafka_spool_path"`
+	KafkaSpoolMaxSize  int           `mapstructure:"kafka_spool_max_size"` // megabytes
+
+	// Sampling is keyed by level name, e.g. "error".
+	Sampling               map[string]SamplingConfig `mapstructure:"sampling"`
+	RateLimit              RateLimitConfig           `mapstructure:"rate_limit"`
+	SuppressReportInterval time.Duration             `mapstructure:"suppress_report_interval"`
+
+	// TraceErrorEvents mirrors Error and above entries logged through WithCtx
+	// as events on the span from the context.
+	TraceErrorEvents    bool `mapstructure:"trace_error_events"`
+	TraceSetErrorStatus bool `mapstructure:"trace_set_error_status"`
+
+	// Redact adds keys and value patterns to the default redaction rules.
+	Redact RedactConfig `mapstructure:"redact"`
 }
 
 type Log struct {
 	logger    *zap.SugaredLogger
 	Config    Config
 	loggerStd *zap.Logger
+	level     zap.AtomicLevel
+	levels    *namedLevels
+	metrics   *logMetrics
+	throttle  *throttle
+	redactor  *redactor
+	kafka     *kafkaSink
+	closers   []closer
 	debug     bool
 }
 
+// closer is a sink sending entries from a background goroutine, stopped by Log.Close.
+type closer interface {
+	Close(ctx context.Context) error
+}
+
 type Fld map[string]any
 type SentryFld map[string]string
 
+// Default returns a new Log writing JSON to stderr at info level.
+// It does not return the logger set by SetDefault, use L for that.
 func Default() *Log {
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	redactor, err := newRedactor(RedactConfig{})
+	if err != nil {
+		panic(err)
+	}
+	levels := &namedLevels{levels: map[string]zapcore.Level{}}
+	metrics := newLogMetrics()
+	core := newLevelCore(newMetricsCore(newRedactCore(stderrCore(), redactor), metrics), level, levels)
+	hook := &exitHook{}
+	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(DefaultCallerSkip), zap.WithFatalHook(hook))
+	hook.sync = logger.Sync
+
+	return &Log{
+		logger:    logger.Sugar(),
+		loggerStd: logger,
+		level:     level,
+		levels:    levels,
+		metrics:   metrics,
+		redactor:  redactor,
+		Config: Config{
+			ContextLogFields: []string{RequestIDField},
+		},
+	}
+}
+
+// stderrCore is the JSON to stderr core used when no outputs are configured.
+// It accepts every level, filtering is done by levelCore.
+func stderrCore() zapcore.Core {
 	cfgDefault := zap.Config{
 		Encoding:         "json",
 		Level:            zap.NewAtomicLevelAt(zapcore.DebugLevel),
 		OutputPaths:      []string{"stderr"},
 		ErrorOutputPaths: []string{"stderr"},
-		EncoderConfig: zapcore.EncoderConfig{
-			MessageKey: "message",
-
-			LevelKey:    "level",
-			EncodeLevel: zapcore.CapitalLevelEncoder,
-
-			TimeKey:    "time",
-			EncodeTime: zapcore.ISO8601TimeEncoder,
-
-			CallerKey:    "caller",
-			EncodeCaller: zapcore.ShortCallerEncoder,
-		},
+		EncoderConfig:    defaultEncoderConfig(),
 	}
 	logger, err := cfgDefault.Build()
 	if err != nil {
 		panic(err)
 	}
 
-	logger = zap.New(logger.Core(), zap.AddCaller(), zap.AddCallerSkip(DefaultCallerSkip))
+	return logger.Core()
+}
 
-	return &Log{
-		logger:    logger.Sugar(),
-		loggerStd: logger,
-		Config: Config{
-			ContextLogFields: []string{RequestIDField},
-		},
+func defaultEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		MessageKey: "message",
+
+		LevelKey:    "level",
+		EncodeLevel: zapcore.CapitalLevelEncoder,
+
+		TimeKey:    "time",
+		EncodeTime: zapcore.ISO8601TimeEncoder,
+
+		CallerKey:    "caller",
+		EncodeCaller: zapcore.ShortCallerEncoder,
+
+		NameKey: "logger",
 	}
 }
 
 func New(cfg Config) *Log {
+	cfg.ContextLogFields = addStr(cfg.ContextLogFields, RequestIDField)
+	if _, err := zapcore.ParseLevel(cfg.LogLevel); err != nil {
+		return nil
+	}
+
+	core, closers, err := outputsCore(cfg)
+	if err != nil {
+		return nil
+	}
+	if cfg.SentryDSN != "" {
+		sentry, err := newSentryCore(cfg)
+		if err != nil {
+			return nil
+		}
+		core = newTee(core, sentry)
+		closers = append(closers, sentry.client)
+	}
+	if cfg.TgToken != "" {
+		telegram, err := newTelegramCore(cfg)
+		if err != nil {
+			return nil
+		}
+		core = newTee(core, telegram)
+		closers = append(closers, telegram.client)
+	}
+	var sink *kafkaSink
+	if len(cfg.KafkaBrokers) > 0 && cfg.KafkaTopic != "" {
+		var kafka *kafkaCore
+		kafka, sink, err = newKafkaCore(cfg)
+		if err != nil {
+			return nil
+		}
+		core = newTee(core, kafka)
+		closers = append(closers, sink)
+	}
+
+	l := NewWithCore(cfg, core)
+	if l != nil {
+		l.kafka = sink
+		l.closers = closers
+	}
+
+	return l
+}
+
+// NewWithCore returns a Log writing to core instead of the outputs, Sentry and Telegram
+// from cfg. Level filtering, redaction and throttling still follow cfg. Entries enabled
+// by core are passed to its Write, so a tee has to filter levels there itself.
+func NewWithCore(cfg Config, core zapcore.Core) *Log {
 	l := Default()
 	l.Config = cfg
 	l.Config.ContextLogFields = addStr(l.Config.ContextLogFields, RequestIDField)
 
-	l.logger = zap.New(l.logger.Desugar().Core(), zap.AddCaller(), zap.AddCallerSkip(cfg.CallerSkip)).Sugar()
-	levelLog := l.logger.Level()
-	err := levelLog.Set(cfg.LogLevel)
+	lvl, err := zapcore.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil
+	}
+	l.level.SetLevel(lvl)
+	l.levels, err = newNamedLevels(cfg.Levels)
+	if err != nil {
+		return nil
+	}
+
+	l.redactor, err = newRedactor(cfg.Redact)
+	if err != nil {
+		return nil
+	}
+	core = newRedactCore(core, l.redactor)
+	l.metrics = newLogMetrics()
+	core = newMetricsCore(core, l.metrics)
+
+	hook := &exitHook{}
+	l.loggerStd = zap.New(newLevelCore(core, l.level, l.levels), zap.AddCaller(), zap.AddCallerSkip(cfg.CallerSkip), zap.WithFatalHook(hook))
+	hook.sync = l.loggerStd.Sync
+	l.logger = l.loggerStd.Sugar()
+
+	l.throttle, err = newThrottle(cfg, l.loggerStd.WithOptions(zap.WithCaller(false)).Sugar())
 	if err != nil {
 		return nil
 	}
@@ -128,55 +276,113 @@ func New(cfg Config) *Log {
 	return l
 }
 
+// KafkaDropped returns the number of entries the Kafka sink could neither send nor spool.
+func (l *Log) KafkaDropped() uint64 {
+	if l.kafka == nil {
+		return 0
+	}
+
+	return l.kafka.Dropped()
+}
+
+// Close flushes and stops the socket outputs, Sentry, Telegram and Kafka sinks
+// shared by l and the loggers derived from it. Entries they log to those sinks
+// afterwards are dropped.
+func (l *Log) Close(ctx context.Context) error {
+	var errs []error
+	for _, c := range l.closers {
+		if err := c.Close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 func (l *Log) GetZapLogger() *zap.Logger {
 	return l.loggerStd
 }
 
-// ToAsync needs a rework to be able to use.
-// So use the default logger for now
-func (l *Log) ToAsync() *AsyncLogger {
-	return NewAsyncLogger(*l)
+// Sync flushes buffered entries, including events queued for Sentry.
+func (l *Log) Sync() error {
+	return l.logger.Sync()
+}
+
+// ToAsync returns an AsyncLogger writing through l.
+func (l *Log) ToAsync(opts ...AsyncOption) *AsyncLogger {
+	return NewAsyncLogger(l, opts...)
 }
 
 func (l *Log) Info(msg string) {
-	l.logger.Info(msg)
+	if l.allow(zapcore.InfoLevel, msg) {
+		l.logger.Info(msg)
+	}
 }
 
 func (l *Log) Infof(msg string, args ...interface{}) {
-	l.logger.Infof(msg, args...)
+	if l.allow(zapcore.InfoLevel, msg) {
+		l.logger.Infof(msg, args...)
+	}
 }
 
 func (l *Log) Infow(msg string, keysAndValues ...interface{}) {
-	l.logger.Infow(msg, keysAndValues...)
+	if l.allow(zapcore.InfoLevel, msg) {
+		l.logger.Infow(msg, keysAndValues...)
+	}
+}
+
+func (l *Log) Warn(msg string) {
+	if l.allow(zapcore.WarnLevel, msg) {
+		l.logger.Warn(msg)
+	}
+}
+
+func (l *Log) Warnf(msg string, args ...interface{}) {
+	if l.allow(zapcore.WarnLevel, msg) {
+		l.logger.Warnf(msg, args...)
+	}
+}
+
+func (l *Log) Warnw(msg string, keysAndValues ...interface{}) {
+	if l.allow(zapcore.WarnLevel, msg) {
+		l.logger.Warnw(msg, keysAndValues...)
+	}
 }
 
 func (l *Log) Error(msg string) {
-	l.logger.Error(msg)
+	if l.allow(zapcore.ErrorLevel, msg) {
+		l.logger.Error(msg)
+	}
 }
 
 func (l *Log) Errorf(msg string, args ...interface{}) {
-	l.logger.Errorf(msg, args...)
+	if l.allow(zapcore.ErrorLevel, msg) {
+		l.logger.Errorf(msg, args...)
+	}
 }
 
 func (l *Log) Errorw(msg string, keysAndValues ...interface{}) {
-	l.logger.Errorw(msg, keysAndValues...)
+	if l.allow(zapcore.ErrorLevel, msg) {
+		l.logger.Errorw(msg, keysAndValues...)
+	}
 }
 
+// Debug logs msg when the level is debug or the logger was derived from a context with DebugField.
 func (l *Log) Debug(msg string) {
-	if l.debug {
+	if l.allow(zapcore.DebugLevel, msg) {
 		l.logger.Debug(msg)
 	}
 }
 
 func (l *Log) Debugf(msg string, args ...interface{}) {
-	if l.debug {
+	if l.allow(zapcore.DebugLevel, msg) {
 		l.logger.Debugf(msg, args...)
 	}
 }
 
 func (l *Log) Debugw(msg string, keysAndValues ...interface{}) {
-	if l.debug {
-		l.logger.Debugw(msg, keysAndValues)
+	if l.allow(zapcore.DebugLevel, msg) {
+		l.logger.Debugw(msg, keysAndValues...)
 	}
 }
 
@@ -193,19 +399,37 @@ func (l *Log) WithField(key string, val interface{}) *Log {
 }
 
 func (l *Log) WithErr(err error) *Log {
-	fields := Fld{}
+	fields := FieldsOf(err)
 	if e, ok := err.(errWithFields); ok {
 		fields["error"] = e.Origin()
-		for k, v := range e.Fields() {
-			fields[k] = v
-		}
 	} else {
 		fields["error"] = err
 	}
+	if code := CodeOf(err); code != "" {
+		fields[ErrorCodeField] = string(code)
+	}
+	var st stackTracer
+	if errors.As(err, &st) {
+		if trace := st.StackTrace(); trace != "" {
+			fields[ErrorStackField] = trace
+		}
+	}
 
 	return l.copyWithEntry(*l.logger).With(fields)
 }
 
+func (l *Log) ErrWithWarn(ctx context.Context, err error, msg string) {
+	l.WithCtx(ctx).WithErr(err).Warn(msg)
+}
+
+func (l *Log) ErrWithWarnf(ctx context.Context, err error, msg string, args ...interface{}) {
+	l.WithCtx(ctx).WithErr(err).Warnf(msg, args...)
+}
+
+func (l *Log) ErrWithWarnw(ctx context.Context, err error, msg string, keysAndValues ...interface{}) {
+	l.WithCtx(ctx).WithErr(err).Warnw(msg, keysAndValues...)
+}
+
 func (l *Log) ErrWithError(ctx context.Context, err error, msg string) {
 	l.WithCtx(ctx).WithErr(err).Error(msg)
 }
@@ -219,17 +443,17 @@ func (l *Log) ErrWithErrorw(ctx context.Context, err error, msg string, keysAndV
 }
 
 func (l *Log) WithCtx(ctx context.Context) *Log {
-	fields := Fld{}
-	for _, key := range l.Config.ContextLogFields {
-		v := ctx.Value(key)
-		if v != nil {
-			fields[key] = v
-		}
-	}
-
-	copied := l.copyWithEntry(*l.logger).With(fields)
-	if ctx.Value(DebugField) != nil {
+	copied := l.copyWithEntry(*l.logger).With(l.contextFields(ctx))
+	if !copied.debug && DebugFromContext(ctx) {
 		copied.debug = true
+		copied.logger = copied.logger.WithOptions(forceDebug())
+		copied.loggerStd = copied.logger.Desugar()
+	}
+	if l.Config.TraceErrorEvents {
+		if span := trace.SpanFromContext(ctx); span.IsRecording() {
+			copied.logger = copied.logger.WithOptions(withSpan(span, l.Config.TraceSetErrorStatus, l.redactor))
+			copied.loggerStd = copied.logger.Desugar()
+		}
 	}
 
 	return copied
@@ -238,7 +462,24 @@ func (l *Log) Panic(msg string) {
 	l.logger.Panic(msg)
 }
 
+// Fatal logs msg, flushes registered flushers and sinks and exits the process.
+func (l *Log) Fatal(msg string) {
+	l.logger.Fatal(msg)
+}
+
+func (l *Log) Fatalf(msg string, args ...interface{}) {
+	l.logger.Fatalf(msg, args...)
+}
+
+func (l *Log) Fatalw(msg string, keysAndValues ...interface{}) {
+	l.logger.Fatalw(msg, keysAndValues...)
+}
+
 func (l *Log) LogGRPC(ctx context.Context, lvl logging.Level, msg string, fields ...any) {
+	if !l.allow(grpcLevel(lvl), msg) {
+		return
+	}
+
 	switch lvl {
 	case logging.LevelDebug:
 		l.logger.Debugw(msg, fields...)
@@ -255,8 +496,40 @@ func (l *Log) LogGRPC(ctx context.Context, lvl logging.Level, msg string, fields
 
 func (l *Log) copyWithEntry(entry zap.SugaredLogger) *Log {
 	return &Log{
-		logger: &entry,
-		Config: l.Config,
+		logger:    &entry,
+		loggerStd: entry.Desugar(),
+		Config:    l.Config,
+		level:     l.level,
+		levels:    l.levels,
+		metrics:   l.metrics,
+		throttle:  l.throttle,
+		redactor:  l.redactor,
+		kafka:     l.kafka,
+		closers:   l.closers,
+		debug:     l.debug,
+	}
+}
+
+// allow applies sampling and rate limiting by message template.
+// Entries disabled by level are left to zap to discard.
+func (l *Log) allow(lvl zapcore.Level, template string) bool {
+	if l.throttle == nil || !l.loggerStd.Core().Enabled(lvl) {
+		return true
+	}
+
+	return l.throttle.allow(lvl, template)
+}
+
+func grpcLevel(lvl logging.Level) zapcore.Level {
+	switch lvl {
+	case logging.LevelDebug:
+		return zapcore.DebugLevel
+	case logging.LevelInfo:
+		return zapcore.InfoLevel
+	case logging.LevelWarn:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
 	}
 }
 
@@ -265,7 +538,7 @@ func LogPanic(recovered interface{}) { // nolint: revive
 	if recovered == nil {
 		return
 	}
-	glog.Errorf("Panic recovered: %s %s", recovered, string(debug.Stack()))
+	skipCaller(L()).Errorf("Panic recovered: %s %s", recovered, string(debug.Stack()))
 }
 
 func (l *Log) LogPanic(recovered interface{}) {
@@ -276,6 +549,9 @@ func (l *Log) LogPanic(recovered interface{}) {
 }
 
 func (l *Log) Log(ctx context.Context, level int, msg string, fields ...interface{}) {
+	if !l.allow(zapcore.Level(level), msg) {
+		return
+	}
 	l.WithCtx(ctx).logger.Logf(zapcore.Level(level), msg, fields...)
 }
 
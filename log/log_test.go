@@ -0,0 +1,165 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// entries decodes the JSON lines written to buf.
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var result []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		result = append(result, entry)
+	}
+
+	return result
+}
+
+// stubExit replaces os.Exit for the test and returns the codes passed to it.
+func stubExit(t *testing.T) *[]int {
+	t.Helper()
+
+	var codes []int
+	orig := osExit
+	osExit = func(code int) {
+		codes = append(codes, code)
+	}
+	t.Cleanup(func() { osExit = orig })
+
+	return &codes
+}
+
+// flushRecorder records what was written to buf when it was flushed.
+type flushRecorder struct {
+	buf     *bytes.Buffer
+	flushed []string
+}
+
+func (f *flushRecorder) Flush(context.Context) {
+	f.flushed = append(f.flushed, f.buf.String())
+}
+
+func TestWarnFamily(t *testing.T) {
+	l, buf := newBufferLog(t, Config{LogLevel: "warn"})
+
+	l.Info("dropped")
+	l.Warn("plain")
+	l.Warnf("formatted %d", 7)
+	l.Warnw("structured", "order_id", 7)
+
+	got := entries(t, buf)
+	if len(got) != 3 {
+		t.Fatalf("got %d entries, want 3: %s", len(got), buf)
+	}
+	for i, msg := range []string{"plain", "formatted 7", "structured"} {
+		if got[i]["level"] != "WARN" || got[i]["message"] != msg {
+			t.Errorf("entry %d = %v, want WARN %q", i, got[i], msg)
+		}
+	}
+	if got[2]["order_id"] != float64(7) {
+		t.Errorf("fields were lost: %v", got[2])
+	}
+}
+
+func TestErrWithFamilies(t *testing.T) {
+	l, buf := newBufferLog(t, Config{})
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	err := Wrap("load order", NewError(CodeNotFound, "order not found"), Fld{"order_id": 7})
+
+	l.ErrWithWarn(ctx, err, "plain")
+	l.ErrWithWarnf(ctx, err, "formatted %d", 1)
+	l.ErrWithWarnw(ctx, err, "structured", "attempt", 2)
+	l.ErrWithError(ctx, err, "plain")
+	l.ErrWithErrorf(ctx, err, "formatted %d", 1)
+	l.ErrWithErrorw(ctx, err, "structured", "attempt", 2)
+
+	got := entries(t, buf)
+	if len(got) != 6 {
+		t.Fatalf("got %d entries, want 6: %s", len(got), buf)
+	}
+	for i, entry := range got {
+		level := "WARN"
+		if i >= 3 {
+			level = "ERROR"
+		}
+		msg := []string{"plain", "formatted 1", "structured"}[i%3]
+		if entry["level"] != level || entry["message"] != msg {
+			t.Errorf("entry %d = %v, want %s %q", i, entry, level, msg)
+		}
+		if entry["error"] != "load order: order not found" || entry[ErrorCodeField] != "not_found" ||
+			entry["order_id"] != float64(7) || entry[RequestIDField] != "req-1" {
+			t.Errorf("entry %d lost the error or context fields: %v", i, entry)
+		}
+		if i%3 == 2 && entry["attempt"] != float64(2) {
+			t.Errorf("entry %d lost its fields: %v", i, entry)
+		}
+	}
+}
+
+func TestFatalFlushesAfterWrite(t *testing.T) {
+	codes := stubExit(t)
+	l, buf := newBufferLog(t, Config{})
+	flusher := &flushRecorder{buf: buf}
+	t.Cleanup(RegisterFlusher(flusher))
+
+	l.Fatal("plain")
+	l.Fatalf("formatted %d", 1)
+	l.With(Fld{"order_id": 7}).Fatalw("structured", "attempt", 2)
+
+	if len(*codes) != 3 || (*codes)[0] != 1 {
+		t.Fatalf("exit codes = %v, want 1 per Fatal call", *codes)
+	}
+	for i, msg := range []string{"plain", "formatted 1", "structured"} {
+		if !strings.Contains(flusher.flushed[i], `"message":"`+msg+`"`) {
+			t.Errorf("flush %d ran before %q was written: %s", i, msg, flusher.flushed[i])
+		}
+	}
+	if last := entries(t, buf)[2]; last["level"] != "FATAL" || last["order_id"] != float64(7) || last["attempt"] != float64(2) {
+		t.Errorf("fatal entry = %v", last)
+	}
+}
+
+func TestAsyncLoggerFatalWritesQueueFirst(t *testing.T) {
+	codes := stubExit(t)
+	l, buf := newBufferLog(t, Config{})
+	async := l.ToAsync()
+	defer async.Shutdown(context.Background())
+
+	for i := 0; i < 100; i++ {
+		async.Infof("queued %d", i)
+	}
+	async.Fatalw("bye", "reason", "test")
+
+	if len(*codes) != 1 {
+		t.Fatalf("exit codes = %v, want one exit", *codes)
+	}
+	got := entries(t, buf)
+	if len(got) != 101 {
+		t.Fatalf("got %d entries, want the queue and the fatal entry", len(got))
+	}
+	if last := got[100]; last["message"] != "bye" || last["level"] != "FATAL" {
+		t.Errorf("last entry = %v, want the fatal one after the queue", last)
+	}
+}
+
+func TestWithErrPlainError(t *testing.T) {
+	l, buf := newBufferLog(t, Config{})
+	l.WithErr(errors.New("boom")).Warn("failed")
+
+	if entry := entries(t, buf)[0]; entry["error"] != "boom" || entry[ErrorCodeField] != "internal" {
+		t.Errorf("entry = %v", entry)
+	}
+}
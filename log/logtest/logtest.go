@@ -0,0 +1,288 @@
+// Package logtest provides an in-memory log.Logger for tests.
+// A Recorder wraps a real *log.Log writing to zap's observer core, so entries
+// go through the same level filtering and redaction as in production and can
+// be asserted on afterwards. Use Recorder.Log where a *log.Log is needed,
+// Recorder.Async for async code and the Recorder itself as a Kafka consumer logger.
+package logtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/D1sordxr/packages/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushTimeout bounds waiting for async loggers before assertions.
+const flushTimeout = time.Second
+
+// Recorder records every entry logged through it.
+type Recorder struct {
+	*log.Log
+
+	logs *observer.ObservedLogs
+
+	mu       sync.Mutex
+	expected map[int]bool
+	flushers []log.Flusher
+	cleanup  func(func())
+}
+
+var _ log.Logger = (*Recorder)(nil)
+
+type options struct {
+	cfg         log.Config
+	failOnError bool
+}
+
+// Option configures a Recorder.
+type Option func(*options)
+
+// WithConfig sets the config of the underlying Log, e.g. to test redaction or levels.
+// Outputs, Sentry and Telegram settings are ignored.
+func WithConfig(cfg log.Config) Option {
+	return func(o *options) {
+		o.cfg = cfg
+	}
+}
+
+// FailOnUnexpectedErrors fails the test at cleanup when entries at error level
+// or above were logged and not matched by AssertLogged.
+func FailOnUnexpectedErrors() Option {
+	return func(o *options) {
+		o.failOnError = true
+	}
+}
+
+// New returns a Recorder logging at debug level unless configured otherwise.
+func New(t testing.TB, opts ...Option) *Recorder {
+	t.Helper()
+
+	o := &options{cfg: log.Config{LogLevel: "debug"}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := log.NewWithCore(o.cfg, core)
+	if l == nil {
+		t.Fatalf("logtest: invalid config %+v", o.cfg)
+	}
+
+	r := &Recorder{Log: l, logs: logs, expected: make(map[int]bool), cleanup: t.Cleanup}
+	if o.failOnError {
+		t.Cleanup(func() {
+			r.failOnUnexpectedErrors(t)
+		})
+	}
+
+	return r
+}
+
+// Async returns an AsyncLogger writing through r.Log. The Recorder flushes it
+// before reading entries and shuts it down when the test ends.
+func (r *Recorder) Async(opts ...log.AsyncOption) *log.AsyncLogger {
+	async := log.NewAsyncLogger(r.Log, opts...)
+	r.AddFlusher(async)
+	r.cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+		async.Shutdown(ctx)
+	})
+
+	return async
+}
+
+// AddFlusher makes the Recorder flush f before reading entries, for loggers
+// buffering entries on their way to r.Log. Other tests' flushers are not touched.
+func (r *Recorder) AddFlusher(f log.Flusher) {
+	r.mu.Lock()
+	r.flushers = append(r.flushers, f)
+	r.mu.Unlock()
+}
+
+// Filter selects recorded entries.
+type Filter func(e observer.LoggedEntry) bool
+
+// Level matches entries at lvl.
+func Level(lvl zapcore.Level) Filter {
+	return func(e observer.LoggedEntry) bool {
+		return e.Level == lvl
+	}
+}
+
+// Message matches entries whose message contains substr.
+func Message(substr string) Filter {
+	return func(e observer.LoggedEntry) bool {
+		return strings.Contains(e.Message, substr)
+	}
+}
+
+// Fields matches entries having all keysAndValues, given as alternating keys and values.
+// Values are compared after zap encoding, so an int matches an int64 field.
+func Fields(keysAndValues ...any) Filter {
+	want := encode(keysAndValues)
+
+	return func(e observer.LoggedEntry) bool {
+		return hasFields(e.ContextMap(), want)
+	}
+}
+
+// Context matches entries logged with the request id and fields stored in ctx.
+func Context(ctx context.Context) Filter {
+	want := map[string]any{}
+	for k, v := range log.FieldsFromContext(ctx) {
+		want[k] = v
+	}
+	if id, ok := log.RequestIDFromContext(ctx); ok {
+		want[log.RequestIDField] = id
+	}
+	want = encode(fldPairs(want))
+
+	return func(e observer.LoggedEntry) bool {
+		return hasFields(e.ContextMap(), want)
+	}
+}
+
+// Entries returns the recorded entries matching all filters, waiting for async loggers first.
+func (r *Recorder) Entries(filters ...Filter) []observer.LoggedEntry {
+	var result []observer.LoggedEntry
+	for _, e := range r.entries(filters) {
+		result = append(result, e.LoggedEntry)
+	}
+
+	return result
+}
+
+// AssertLogged fails t unless an entry at lvl containing msgSubstring and
+// keysAndValues was logged. Matched error entries count as expected.
+func (r *Recorder) AssertLogged(t testing.TB, lvl zapcore.Level, msgSubstring string, keysAndValues ...any) {
+	t.Helper()
+
+	matched := r.entries([]Filter{Level(lvl), Message(msgSubstring), Fields(keysAndValues...)})
+	if len(matched) == 0 {
+		t.Errorf("logtest: no %s entry containing %q with fields %v, recorded:\n%s",
+			lvl, msgSubstring, keysAndValues, r.dump())
+		return
+	}
+
+	r.mu.Lock()
+	for _, e := range matched {
+		r.expected[e.index] = true
+	}
+	r.mu.Unlock()
+}
+
+// AssertNotLogged fails t if an entry at lvl containing msgSubstring was logged.
+func (r *Recorder) AssertNotLogged(t testing.TB, lvl zapcore.Level, msgSubstring string) {
+	t.Helper()
+
+	if matched := r.entries([]Filter{Level(lvl), Message(msgSubstring)}); len(matched) > 0 {
+		t.Errorf("logtest: unexpected %s entry containing %q: %s", lvl, msgSubstring, matched[0].Message)
+	}
+}
+
+// Reset drops the recorded entries.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logs.TakeAll()
+	r.expected = make(map[int]bool)
+}
+
+type indexedEntry struct {
+	observer.LoggedEntry
+	index int
+}
+
+func (r *Recorder) entries(filters []Filter) []indexedEntry {
+	r.flush()
+
+	var result []indexedEntry
+next:
+	for i, e := range r.logs.All() {
+		for _, f := range filters {
+			if !f(e) {
+				continue next
+			}
+		}
+		result = append(result, indexedEntry{LoggedEntry: e, index: i})
+	}
+
+	return result
+}
+
+func (r *Recorder) flush() {
+	r.mu.Lock()
+	flushers := append([]log.Flusher(nil), r.flushers...)
+	r.mu.Unlock()
+	if len(flushers) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	for _, f := range flushers {
+		f.Flush(ctx)
+	}
+}
+
+func (r *Recorder) failOnUnexpectedErrors(t testing.TB) {
+	t.Helper()
+
+	for _, e := range r.entries(nil) {
+		r.mu.Lock()
+		expected := r.expected[e.index]
+		r.mu.Unlock()
+
+		if e.Level >= zapcore.ErrorLevel && !expected {
+			t.Errorf("logtest: unexpected %s entry: %s %v", e.Level, e.Message, e.ContextMap())
+		}
+	}
+}
+
+func (r *Recorder) dump() string {
+	var b strings.Builder
+	for _, e := range r.logs.All() {
+		fmt.Fprintf(&b, "\t%s %s %v\n", e.Level, e.Message, e.ContextMap())
+	}
+
+	return b.String()
+}
+
+// encode turns key-value pairs into the values observer reports in ContextMap.
+func encode(keysAndValues []any) map[string]any {
+	enc := zapcore.NewMapObjectEncoder()
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		zap.Any(fmt.Sprint(keysAndValues[i]), keysAndValues[i+1]).AddTo(enc)
+	}
+
+	return enc.Fields
+}
+
+func fldPairs(fields map[string]any) []any {
+	pairs := make([]any, 0, len(fields)*2)
+	for k, v := range fields {
+		pairs = append(pairs, k, v)
+	}
+
+	return pairs
+}
+
+func hasFields(got, want map[string]any) bool {
+	for k, v := range want {
+		if !reflect.DeepEqual(got[k], v) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,129 @@
+package logtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/D1sordxr/packages/log"
+	"go.uber.org/zap/zapcore"
+	"strings"
+	"testing"
+)
+
+// fakeT records failures and cleanups instead of failing the test.
+type fakeT struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) Fatalf(format string, args ...any) {
+	f.Errorf(format, args...)
+}
+
+func (f *fakeT) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+func (f *fakeT) runCleanups() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+func TestAssertLogged(t *testing.T) {
+	r := New(t)
+	r.Infow("order created", "order_id", 7, "status", "new")
+
+	r.AssertLogged(t, zapcore.InfoLevel, "created", "order_id", 7)
+	r.AssertLogged(t, zapcore.InfoLevel, "order", "order_id", int64(7), "status", "new")
+	r.AssertNotLogged(t, zapcore.ErrorLevel, "order")
+
+	ft := &fakeT{}
+	r.AssertLogged(ft, zapcore.InfoLevel, "created", "order_id", 8)
+	r.AssertLogged(ft, zapcore.WarnLevel, "created")
+	r.AssertNotLogged(ft, zapcore.InfoLevel, "created")
+	if len(ft.errors) != 3 {
+		t.Fatalf("got %d failures, want 3: %q", len(ft.errors), ft.errors)
+	}
+	if !strings.Contains(ft.errors[0], "order created") {
+		t.Errorf("failure does not list the recorded entries: %s", ft.errors[0])
+	}
+
+	r.Reset()
+	if got := r.Entries(); len(got) != 0 {
+		t.Errorf("entries after Reset = %v", got)
+	}
+}
+
+func TestContextFilter(t *testing.T) {
+	r := New(t)
+	ctx := log.ContextWithFields(log.ContextWithRequestID(context.Background(), "req-1"), log.Fld{"tenant": "acme"})
+	other := log.ContextWithRequestID(context.Background(), "req-2")
+
+	r.WithCtx(ctx).Info("first")
+	r.WithCtx(other).Info("second")
+	r.Info("third")
+
+	got := r.Entries(Context(ctx))
+	if len(got) != 1 || got[0].Message != "first" {
+		t.Errorf("entries for ctx = %v, want the first one", got)
+	}
+	if got = r.Entries(Context(other), Level(zapcore.InfoLevel)); len(got) != 1 || got[0].Message != "second" {
+		t.Errorf("entries for other = %v, want the second one", got)
+	}
+}
+
+func TestFailOnUnexpectedErrors(t *testing.T) {
+	ft := &fakeT{}
+	r := New(ft, FailOnUnexpectedErrors())
+	r.Error("known failure")
+	r.Errorw("unexpected failure", "attempt", 2)
+	r.Warn("warnings are fine")
+
+	r.AssertLogged(ft, zapcore.ErrorLevel, "known failure")
+	ft.runCleanups()
+
+	if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "unexpected failure") {
+		t.Errorf("failures = %q, want only the unexpected error", ft.errors)
+	}
+}
+
+// countingFlusher counts how often it was flushed.
+type countingFlusher struct {
+	flushed int
+}
+
+func (f *countingFlusher) Flush(context.Context) {
+	f.flushed++
+}
+
+func TestAsyncFlushedBeforeAssertions(t *testing.T) {
+	global := &countingFlusher{}
+	t.Cleanup(log.RegisterFlusher(global))
+	attached := &countingFlusher{}
+
+	r := New(t)
+	r.AddFlusher(attached)
+	async := r.Async()
+	for i := 0; i < 100; i++ {
+		async.Infof("queued %d", i)
+	}
+
+	if got := r.Entries(Message("queued")); len(got) != 100 {
+		t.Errorf("got %d entries, want the whole queue", len(got))
+	}
+	r.AssertLogged(t, zapcore.InfoLevel, "queued 99")
+	if attached.flushed != 2 {
+		t.Errorf("attached flusher ran %d times, want 2", attached.flushed)
+	}
+	// Flushers of other tests and sinks are left alone.
+	if global.flushed != 0 {
+		t.Errorf("process-wide flusher ran %d times", global.flushed)
+	}
+}
@@ -0,0 +1,178 @@
+package log
+
+import (
+	"bufio"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type entryKey struct {
+	level  zapcore.Level
+	logger string
+	code   string
+}
+
+// logMetrics counts entries written by a Log and its children and tracks
+// the AsyncLogger queues writing through them.
+type logMetrics struct {
+	mu      sync.RWMutex
+	entries map[entryKey]*atomic.Uint64
+
+	queuesMu sync.Mutex
+	queues   map[*asyncQueue]struct{}
+	// droppedClosed keeps the drops of shut down queues so the counter never decreases.
+	droppedClosed uint64
+}
+
+func newLogMetrics() *logMetrics {
+	return &logMetrics{
+		entries: map[entryKey]*atomic.Uint64{},
+		queues:  map[*asyncQueue]struct{}{},
+	}
+}
+
+func (m *logMetrics) inc(key entryKey) {
+	m.mu.RLock()
+	counter, ok := m.entries[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		m.mu.Lock()
+		if counter, ok = m.entries[key]; !ok {
+			counter = &atomic.Uint64{}
+			m.entries[key] = counter
+		}
+		m.mu.Unlock()
+	}
+	counter.Add(1)
+}
+
+func (m *logMetrics) addQueue(q *asyncQueue) {
+	m.queuesMu.Lock()
+	m.queues[q] = struct{}{}
+	m.queuesMu.Unlock()
+}
+
+func (m *logMetrics) removeQueue(q *asyncQueue) {
+	m.queuesMu.Lock()
+	if _, ok := m.queues[q]; ok {
+		delete(m.queues, q)
+		m.droppedClosed += q.dropped.Load()
+	}
+	m.queuesMu.Unlock()
+}
+
+func (m *logMetrics) queueStats() (depth int, dropped uint64) {
+	m.queuesMu.Lock()
+	defer m.queuesMu.Unlock()
+
+	dropped = m.droppedClosed
+	for q := range m.queues {
+		depth += len(q.logChan)
+		dropped += q.dropped.Load()
+	}
+
+	return depth, dropped
+}
+
+// metricsCore counts the entries reaching the wrapped core by level, logger name and error code.
+type metricsCore struct {
+	zapcore.Core
+	metrics *logMetrics
+	code    string
+}
+
+func newMetricsCore(core zapcore.Core, m *logMetrics) zapcore.Core {
+	return &metricsCore{Core: core, metrics: m}
+}
+
+func (c *metricsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &metricsCore{Core: c.Core.With(fields), metrics: c.metrics, code: errorCodeOf(fields, c.code)}
+}
+
+func (c *metricsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Core.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *metricsCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	c.metrics.inc(entryKey{level: ent.Level, logger: ent.LoggerName, code: errorCodeOf(fields, c.code)})
+
+	return c.Core.Write(ent, fields)
+}
+
+func errorCodeOf(fields []zapcore.Field, code string) string {
+	for _, f := range fields {
+		if f.Key != ErrorCodeField {
+			continue
+		}
+		if f.Type == zapcore.StringType {
+			code = f.String
+		} else if c, ok := f.Interface.(Code); ok {
+			code = string(c)
+		}
+	}
+
+	return code
+}
+
+// MetricsHandler returns an http.Handler exposing in the Prometheus text format
+// the entries written by l and its children per level, logger name and error code,
+// the depth and drops of AsyncLogger queues and the drops of the Kafka sink.
+func (l *Log) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+
+		bw := bufio.NewWriter(w)
+		l.writeMetrics(bw)
+		_ = bw.Flush()
+	})
+}
+
+func (l *Log) writeMetrics(w *bufio.Writer) {
+	l.metrics.mu.RLock()
+	lines := make([]string, 0, len(l.metrics.entries))
+	for key, counter := range l.metrics.entries {
+		lines = append(lines, fmt.Sprintf(
+			"log_entries_total{level=\"%s\",logger=\"%s\",error_code=\"%s\"} %d\n",
+			key.level.String(), escapeLabel(key.logger), escapeLabel(key.code), counter.Load(),
+		))
+	}
+	l.metrics.mu.RUnlock()
+	sort.Strings(lines)
+
+	writeMetricHeader(w, "log_entries_total", "counter", "Log entries written by level, logger name and error code.")
+	for _, line := range lines {
+		_, _ = w.WriteString(line)
+	}
+
+	depth, dropped := l.metrics.queueStats()
+	writeMetricHeader(w, "log_async_queue_depth", "gauge", "Messages waiting in AsyncLogger queues.")
+	_, _ = fmt.Fprintf(w, "log_async_queue_depth %d\n", depth)
+	writeMetricHeader(w, "log_async_dropped_total", "counter", "Messages dropped by full AsyncLogger queues.")
+	_, _ = fmt.Fprintf(w, "log_async_dropped_total %d\n", dropped)
+
+	writeMetricHeader(w, "log_kafka_dropped_total", "counter", "Entries the Kafka sink could neither send nor spool.")
+	_, _ = fmt.Fprintf(w, "log_kafka_dropped_total %d\n", l.KafkaDropped())
+}
+
+func writeMetricHeader(w *bufio.Writer, name, typ, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value as the exposition format requires.
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
@@ -0,0 +1,138 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingCore accepts every entry and blocks its writes until release is closed.
+type blockingCore struct {
+	zapcore.LevelEnabler
+	release chan struct{}
+	writing chan struct{}
+}
+
+func (c *blockingCore) With([]zapcore.Field) zapcore.Core {
+	return c
+}
+
+func (c *blockingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
+func (c *blockingCore) Write(zapcore.Entry, []zapcore.Field) error {
+	select {
+	case c.writing <- struct{}{}:
+	default:
+	}
+	<-c.release
+	return nil
+}
+
+func (c *blockingCore) Sync() error {
+	return nil
+}
+
+func scrape(t *testing.T, h http.Handler) string {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func assertMetric(t *testing.T, body, line string) {
+	t.Helper()
+
+	for _, l := range strings.Split(body, "\n") {
+		if l == line {
+			return
+		}
+	}
+	t.Errorf("metric %q not found in:\n%s", line, body)
+}
+
+func TestMetricsHandlerEntries(t *testing.T) {
+	l, _ := newBufferLog(t, Config{LogLevel: "info"})
+
+	l.Info("a")
+	l.Info("b")
+	l.Debug("filtered by level")
+	l.Named("db").Named("pool").Warn("w")
+	l.WithErr(Wrap("query", errors.New("no rows"), nil, WithCode(CodeNotFound))).Error("e")
+	l.Named(`we"ird`).Errorw("e", ErrorCodeField, CodeConflict)
+
+	body := scrape(t, l.MetricsHandler())
+
+	assertMetric(t, body, "# TYPE log_entries_total counter")
+	assertMetric(t, body, `log_entries_total{level="info",logger="",error_code=""} 2`)
+	assertMetric(t, body, `log_entries_total{level="warn",logger="db.pool",error_code=""} 1`)
+	assertMetric(t, body, `log_entries_total{level="error",logger="",error_code="not_found"} 1`)
+	assertMetric(t, body, `log_entries_total{level="error",logger="we\"ird",error_code="conflict"} 1`)
+	if strings.Contains(body, `level="debug"`) {
+		t.Errorf("filtered entries must not be counted:\n%s", body)
+	}
+	assertMetric(t, body, "log_async_queue_depth 0")
+	assertMetric(t, body, "log_async_dropped_total 0")
+	assertMetric(t, body, "log_kafka_dropped_total 0")
+}
+
+func TestMetricsHandlerAsyncQueues(t *testing.T) {
+	core := &blockingCore{LevelEnabler: zapcore.DebugLevel, release: make(chan struct{}), writing: make(chan struct{}, 1)}
+	l := NewWithCore(Config{LogLevel: "info"}, core)
+	async := l.ToAsync(WithBufferSize(2), WithOverflowStrategy(Drop), WithDropReportInterval(0))
+
+	async.Info("picked by the processor")
+	select {
+	case <-core.writing:
+	case <-time.After(time.Second):
+		t.Fatal("processor did not start writing")
+	}
+	for i := 0; i < 5; i++ {
+		async.Info("queued or dropped")
+	}
+
+	body := scrape(t, l.MetricsHandler())
+	assertMetric(t, body, "log_async_queue_depth 2")
+	assertMetric(t, body, "log_async_dropped_total 3")
+
+	close(core.release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	async.Shutdown(ctx)
+
+	body = scrape(t, l.MetricsHandler())
+	assertMetric(t, body, "log_async_queue_depth 0")
+	assertMetric(t, body, "log_async_dropped_total 3")
+	assertMetric(t, body, `log_entries_total{level="info",logger="",error_code=""} 3`)
+}
+
+func TestMetricsHandlerKafkaDropped(t *testing.T) {
+	l, _ := newBufferLog(t, Config{})
+	l.kafka = &kafkaSink{}
+	l.kafka.dropped.Add(7)
+
+	assertMetric(t, scrape(t, l.MetricsHandler()), "log_kafka_dropped_total 7")
+}
@@ -0,0 +1,481 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EncodingJSON    = "json"
+	EncodingConsole = "console"
+	EncodingLogfmt  = "logfmt"
+
+	socketDialTimeout  = time.Second
+	socketRetryPeriod  = time.Second
+	socketWriteTimeout = time.Second
+	socketFlushTimeout = 2 * time.Second
+	socketBufferSize   = 1000
+)
+
+// OutputConfig describes one destination of log entries.
+type OutputConfig struct {
+	// Encoding is "json" (default), "console" or "logfmt".
+	Encoding string `mapstructure:"encoding"`
+	// Path is "stderr", "stdout", a file path, "unix:///path/to.sock" or "tcp://host:port".
+	Path string `mapstructure:"path"`
+	// Level is the minimum level written to this output, on top of LogLevel.
+	Level string `mapstructure:"level"`
+}
+
+// EncoderKeys overrides the keys of the entry fields, empty values keep the defaults.
+// Set a key to "-" to omit the field.
+type EncoderKeys struct {
+	MessageKey    string `mapstructure:"message_key"`
+	LevelKey      string `mapstructure:"level_key"`
+	TimeKey       string `mapstructure:"time_key"`
+	CallerKey     string `mapstructure:"caller_key"`
+	NameKey       string `mapstructure:"name_key"`
+	StacktraceKey string `mapstructure:"stacktrace_key"`
+}
+
+func encoderConfig(keys EncoderKeys) zapcore.EncoderConfig {
+	cfg := defaultEncoderConfig()
+	set := func(dst *string, key string) {
+		switch key {
+		case "":
+		case "-":
+			*dst = zapcore.OmitKey
+		default:
+			*dst = key
+		}
+	}
+	set(&cfg.MessageKey, keys.MessageKey)
+	set(&cfg.LevelKey, keys.LevelKey)
+	set(&cfg.TimeKey, keys.TimeKey)
+	set(&cfg.CallerKey, keys.CallerKey)
+	set(&cfg.NameKey, keys.NameKey)
+	set(&cfg.StacktraceKey, keys.StacktraceKey)
+
+	return cfg
+}
+
+// outputsCore builds a core writing to every output of cfg. OutputPaths are
+// JSON outputs, stderr is used when nothing is configured. It also returns the
+// socket writers, which Log.Close stops.
+func outputsCore(cfg Config) (zapcore.Core, []closer, error) {
+	outputs := make([]OutputConfig, 0, len(cfg.OutputPaths)+len(cfg.Outputs))
+	for _, path := range cfg.OutputPaths {
+		outputs = append(outputs, OutputConfig{Path: path})
+	}
+	outputs = append(outputs, cfg.Outputs...)
+	if len(outputs) == 0 {
+		outputs = append(outputs, OutputConfig{Path: "stderr"})
+	}
+
+	encCfg := encoderConfig(cfg.EncoderKeys)
+	cores := make([]zapcore.Core, 0, len(outputs))
+	var closers []closer
+	for _, out := range outputs {
+		core, ws, err := outputCore(out, encCfg, cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("output %q: %w", out.Path, err)
+		}
+		cores = append(cores, core)
+		if c, ok := ws.(closer); ok {
+			closers = append(closers, c)
+		}
+	}
+
+	return newTee(cores...), closers, nil
+}
+
+// teeCore duplicates entries to several cores like zapcore.NewTee, but its Write
+// passes an entry only to the cores enabled for its level and returns their errors.
+// Wrapping cores can therefore write through it instead of checking it again.
+type teeCore []zapcore.Core
+
+func newTee(cores ...zapcore.Core) zapcore.Core {
+	tee := make(teeCore, 0, len(cores))
+	for _, core := range cores {
+		if inner, ok := core.(teeCore); ok {
+			tee = append(tee, inner...)
+			continue
+		}
+		tee = append(tee, core)
+	}
+
+	switch len(tee) {
+	case 0:
+		return zapcore.NewNopCore()
+	case 1:
+		return tee[0]
+	default:
+		return tee
+	}
+}
+
+func (t teeCore) Enabled(lvl zapcore.Level) bool {
+	for _, core := range t {
+		if core.Enabled(lvl) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t teeCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := make(teeCore, len(t))
+	for i, core := range t {
+		clone[i] = core.With(fields)
+	}
+
+	return clone
+}
+
+func (t teeCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	for _, core := range t {
+		ce = core.Check(ent, ce)
+	}
+
+	return ce
+}
+
+func (t teeCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	var errs []error
+	for _, core := range t {
+		if !core.Enabled(ent.Level) {
+			continue
+		}
+		if err := core.Write(ent, fields); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (t teeCore) Sync() error {
+	var errs []error
+	for _, core := range t {
+		if err := core.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func outputCore(out OutputConfig, encCfg zapcore.EncoderConfig, cfg Config) (zapcore.Core, zapcore.WriteSyncer, error) {
+	var enc zapcore.Encoder
+	switch out.Encoding {
+	case "", EncodingJSON:
+		enc = zapcore.NewJSONEncoder(encCfg)
+	case EncodingConsole:
+		enc = zapcore.NewConsoleEncoder(encCfg)
+	case EncodingLogfmt:
+		enc = newLogfmtEncoder(encCfg)
+	default:
+		return nil, nil, fmt.Errorf("unknown encoding %q", out.Encoding)
+	}
+
+	level := zapcore.DebugLevel
+	if out.Level != "" {
+		var err error
+		if level, err = zapcore.ParseLevel(out.Level); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ws, err := openOutput(out.Path, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return zapcore.NewCore(enc, ws, level), ws, nil
+}
+
+func openOutput(path string, cfg Config) (zapcore.WriteSyncer, error) {
+	switch {
+	case path == "stderr":
+		return zapcore.Lock(os.Stderr), nil
+	case path == "stdout":
+		return zapcore.Lock(os.Stdout), nil
+	case strings.HasPrefix(path, "unix://"):
+		return newSocketWriter("unix", strings.TrimPrefix(path, "unix://")), nil
+	case strings.HasPrefix(path, "tcp://"):
+		return newSocketWriter("tcp", strings.TrimPrefix(path, "tcp://")), nil
+	default:
+		return openFile(path, cfg)
+	}
+}
+
+// socketWriter writes entries to a unix or tcp socket. Write only queues the
+// entry, a background goroutine connects, redials at most once per
+// socketRetryPeriod and sends the queue in order. While the peer is unreachable
+// the queue fills up and further entries are dropped, so a dead collector never
+// blocks logging. Close stops the goroutine.
+type socketWriter struct {
+	network string
+	addr    string
+
+	queue    *sendQueue[[]byte]
+	dropped  atomic.Uint64
+	dropping atomic.Bool
+
+	// conn is only used by the sending goroutine until it is stopped.
+	conn      net.Conn
+	closeOnce sync.Once
+}
+
+func newSocketWriter(network, addr string) *socketWriter {
+	w := &socketWriter{network: network, addr: addr}
+	w.queue = newSendQueue(socketBufferSize, w.sendRetry)
+
+	return w
+}
+
+// Write queues a copy of p. When the queue is full the entry is dropped, only
+// the first drop of a series is returned as an error to avoid flooding the error output.
+func (w *socketWriter) Write(p []byte) (int, error) {
+	entry := make([]byte, len(p))
+	copy(entry, p)
+
+	if w.queue.enqueue(entry) {
+		w.dropping.Store(false)
+		return len(p), nil
+	}
+
+	w.dropped.Add(1)
+	if w.dropping.CompareAndSwap(false, true) {
+		return 0, fmt.Errorf("%s %s: buffer full, dropping entries until the peer is reachable", w.network, w.addr)
+	}
+
+	return len(p), nil
+}
+
+// Dropped returns the number of entries dropped because the queue was full.
+func (w *socketWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// sendRetry sends p until it succeeds or the writer is closed.
+func (w *socketWriter) sendRetry(p []byte, done <-chan struct{}) {
+	for !w.send(p) {
+		select {
+		case <-done:
+			return
+		case <-time.After(socketRetryPeriod):
+		}
+	}
+}
+
+func (w *socketWriter) send(p []byte) bool {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.addr, socketDialTimeout)
+		if err != nil {
+			return false
+		}
+		w.conn = conn
+	}
+
+	_ = w.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	if _, err := w.conn.Write(p); err != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+		return false
+	}
+
+	return true
+}
+
+// Sync waits for the queued entries to be sent, at most socketFlushTimeout.
+func (w *socketWriter) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), socketFlushTimeout)
+	defer cancel()
+
+	if !w.queue.flush(ctx) {
+		return fmt.Errorf("%s %s: flush timed out", w.network, w.addr)
+	}
+
+	return nil
+}
+
+// Close sends the queued entries until ctx is done, stops the sending goroutine
+// and closes the connection. Entries written afterwards are dropped.
+func (w *socketWriter) Close(ctx context.Context) error {
+	var err error
+	w.closeOnce.Do(func() {
+		if err = w.queue.close(ctx); err != nil {
+			err = fmt.Errorf("%s %s: %w", w.network, w.addr, err)
+			return
+		}
+		if w.conn != nil {
+			_ = w.conn.Close()
+		}
+	})
+
+	return err
+}
+
+var logfmtPool = buffer.NewPool()
+
+// logfmtEncoder writes entries as key=value pairs. Context fields are sorted
+// by key, nested objects are flattened with dotted keys and arrays are written as JSON.
+type logfmtEncoder struct {
+	*zapcore.MapObjectEncoder
+	cfg zapcore.EncoderConfig
+	// namespaces are the open namespaces, needed to clone the encoder cursor.
+	namespaces []string
+}
+
+func newLogfmtEncoder(cfg zapcore.EncoderConfig) *logfmtEncoder {
+	return &logfmtEncoder{MapObjectEncoder: zapcore.NewMapObjectEncoder(), cfg: cfg}
+}
+
+func (e *logfmtEncoder) OpenNamespace(key string) {
+	e.namespaces = append(e.namespaces[:len(e.namespaces):len(e.namespaces)], key)
+	e.MapObjectEncoder.OpenNamespace(key)
+}
+
+// Clone copies the fields level by level, reopening namespaces so that
+// fields added to the clone land in the innermost one.
+func (e *logfmtEncoder) Clone() zapcore.Encoder {
+	clone := newLogfmtEncoder(e.cfg)
+	fields := e.Fields
+	for i := 0; ; i++ {
+		var ns string
+		if i < len(e.namespaces) {
+			ns = e.namespaces[i]
+		}
+		for k, v := range fields {
+			if i < len(e.namespaces) && k == ns {
+				continue
+			}
+			clone.AddReflected(k, v)
+		}
+		if i == len(e.namespaces) {
+			return clone
+		}
+		clone.OpenNamespace(ns)
+		fields, _ = fields[ns].(map[string]any)
+	}
+}
+
+func (e *logfmtEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	buf := logfmtPool.Get()
+
+	if e.cfg.TimeKey != zapcore.OmitKey && e.cfg.TimeKey != "" && !ent.Time.IsZero() {
+		appendLogfmt(buf, e.cfg.TimeKey, ent.Time.Format("2006-01-02T15:04:05.000Z0700"))
+	}
+	if e.cfg.LevelKey != zapcore.OmitKey && e.cfg.LevelKey != "" {
+		appendLogfmt(buf, e.cfg.LevelKey, ent.Level.String())
+	}
+	if e.cfg.NameKey != zapcore.OmitKey && e.cfg.NameKey != "" && ent.LoggerName != "" {
+		appendLogfmt(buf, e.cfg.NameKey, ent.LoggerName)
+	}
+	if e.cfg.CallerKey != zapcore.OmitKey && e.cfg.CallerKey != "" && ent.Caller.Defined {
+		appendLogfmt(buf, e.cfg.CallerKey, ent.Caller.TrimmedPath())
+	}
+	if e.cfg.MessageKey != zapcore.OmitKey && e.cfg.MessageKey != "" {
+		appendLogfmt(buf, e.cfg.MessageKey, ent.Message)
+	}
+
+	enc := e.Clone().(*logfmtEncoder)
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	flat := map[string]any{}
+	flattenLogfmt(flat, "", enc.Fields)
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		appendLogfmt(buf, k, flat[k])
+	}
+
+	if e.cfg.StacktraceKey != zapcore.OmitKey && e.cfg.StacktraceKey != "" && ent.Stack != "" {
+		appendLogfmt(buf, e.cfg.StacktraceKey, ent.Stack)
+	}
+	buf.AppendString("\n")
+
+	return buf, nil
+}
+
+func flattenLogfmt(dst map[string]any, prefix string, fields map[string]any) {
+	for k, v := range fields {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]any); ok {
+			flattenLogfmt(dst, k, nested)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+func appendLogfmt(buf *buffer.Buffer, key string, val any) {
+	if buf.Len() > 0 {
+		buf.AppendByte(' ')
+	}
+	buf.AppendString(key)
+	buf.AppendByte('=')
+
+	var s string
+	switch v := val.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	case bool:
+		buf.AppendBool(v)
+		return
+	case int64:
+		buf.AppendInt(v)
+		return
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+			break
+		}
+		buf.AppendFloat(v, 64)
+		return
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	case []any, map[string]any:
+		b, err := json.Marshal(v)
+		if err != nil {
+			s = fmt.Sprint(v)
+			break
+		}
+		s = string(b)
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\\\n\t") || !strconv.CanBackquote(s) {
+		buf.AppendString(strconv.Quote(s))
+		return
+	}
+	buf.AppendString(s)
+}
@@ -0,0 +1,138 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSocketWriterDelivers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+
+	l := New(Config{LogLevel: "info", Outputs: []OutputConfig{{Path: "unix://" + path, Encoding: EncodingLogfmt}}})
+	if l == nil {
+		t.Fatal("New returned nil")
+	}
+	l.Infow("first", "n", 1)
+	l.Infow("second", "n", 2)
+	if err = l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"message=first", "message=second"} {
+		select {
+		case line := <-received:
+			if !strings.Contains(line, want) {
+				t.Errorf("line %q does not contain %q", line, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q not received", want)
+		}
+	}
+}
+
+func TestSocketWriterNeverBlocks(t *testing.T) {
+	// Nothing listens on the socket, every dial fails and entries pile up.
+	w := newSocketWriter("unix", filepath.Join(t.TempDir(), "missing.sock"))
+
+	start := time.Now()
+	var errs int
+	for i := 0; i < socketBufferSize+100; i++ {
+		if _, err := w.Write([]byte("entry\n")); err != nil {
+			errs++
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("writes took %v while the peer is down", elapsed)
+	}
+	// The sender may hold one entry outside the queue.
+	if dropped := w.Dropped(); dropped < 99 || dropped > 100 {
+		t.Errorf("dropped %d entries, want 100", dropped)
+	}
+	if errs != 1 {
+		t.Errorf("%d writes failed, want only the first drop reported", errs)
+	}
+}
+
+func TestSocketWriterCloseWhilePeerIsDown(t *testing.T) {
+	w := newSocketWriter("unix", filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := w.Write([]byte("entry\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_ = w.Close(ctx)
+
+	// The retry loop gives up instead of waiting for the peer.
+	select {
+	case <-w.queue.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("sending goroutine was not stopped")
+	}
+	if _, err := w.Write([]byte("late\n")); err == nil || w.Dropped() != 1 {
+		t.Errorf("write after Close = %v, dropped %d", err, w.Dropped())
+	}
+}
+
+func TestLogCloseStopsSocketOutputs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	l := New(Config{LogLevel: "info", Outputs: []OutputConfig{{Path: "unix://" + path, Encoding: EncodingLogfmt}}})
+	if l == nil {
+		t.Fatal("New returned nil")
+	}
+	l.Info("last")
+	if err = l.Named("child").Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close sent the entry and closed the connection, so the peer reads EOF.
+	select {
+	case data := <-received:
+		if !strings.Contains(data, "message=last") {
+			t.Errorf("received %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed")
+	}
+}
@@ -0,0 +1,110 @@
+package log
+
+import (
+	"context"
+	"sync"
+)
+
+// sendQueue hands items to a background goroutine in order. Like
+// AsyncLogger.Flush, flush puts a marker on the queue and waits for the
+// goroutine to reach it, so a timed out flush leaves no goroutine behind.
+type sendQueue[T any] struct {
+	items chan queued[T]
+	// send delivers one item, it should give up once done is closed.
+	send func(item T, done <-chan struct{})
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+type queued[T any] struct {
+	item    T
+	flushed chan struct{}
+}
+
+// newSendQueue starts the goroutine calling send for every queued item.
+func newSendQueue[T any](size int, send func(item T, done <-chan struct{})) *sendQueue[T] {
+	q := &sendQueue[T]{
+		items:   make(chan queued[T], size),
+		send:    send,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go q.run()
+
+	return q
+}
+
+// enqueue adds item without blocking and reports false when the queue is full or closed.
+func (q *sendQueue[T]) enqueue(item T) bool {
+	select {
+	case <-q.done:
+		return false
+	default:
+	}
+
+	select {
+	case q.items <- queued[T]{item: item}:
+		return true
+	default:
+		return false
+	}
+}
+
+// flush waits until the items queued before the call are sent and reports
+// whether that happened before ctx was done.
+func (q *sendQueue[T]) flush(ctx context.Context) bool {
+	flushed := make(chan struct{})
+	select {
+	case q.items <- queued[T]{flushed: flushed}:
+	case <-q.done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+
+	select {
+	case <-flushed:
+		return true
+	case <-q.stopped:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// close flushes the queue and stops the goroutine. Items still queued when ctx
+// is done are dropped.
+func (q *sendQueue[T]) close(ctx context.Context) error {
+	var err error
+	q.closeOnce.Do(func() {
+		q.flush(ctx)
+		close(q.done)
+
+		select {
+		case <-q.stopped:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+
+	return err
+}
+
+func (q *sendQueue[T]) run() {
+	defer close(q.stopped)
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case it := <-q.items:
+			if it.flushed != nil {
+				close(it.flushed)
+				continue
+			}
+			q.send(it.item, q.done)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package log
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendQueueFlush(t *testing.T) {
+	var mu sync.Mutex
+	var sent []int
+	q := newSendQueue(10, func(n int, _ <-chan struct{}) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		sent = append(sent, n)
+		mu.Unlock()
+	})
+	defer q.close(context.Background())
+
+	for i := 0; i < 5; i++ {
+		q.enqueue(i)
+	}
+	if !q.flush(context.Background()) {
+		t.Fatal("flush failed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 5 {
+		t.Errorf("sent %v before flush returned, want all 5", sent)
+	}
+}
+
+func TestSendQueueFlushTimeoutLeavesNoGoroutine(t *testing.T) {
+	release := make(chan struct{})
+	q := newSendQueue(10, func(_ int, done <-chan struct{}) {
+		select {
+		case <-release:
+		case <-done:
+		}
+	})
+	q.enqueue(1)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		if q.flush(ctx) {
+			t.Fatal("flush succeeded while the item is stuck")
+		}
+		cancel()
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines after the timed out flushes, %d before", after, before)
+	}
+
+	// The markers left on the queue are skipped once the sender moves on.
+	close(release)
+	if !q.flush(context.Background()) {
+		t.Error("flush failed after the sender recovered")
+	}
+	if err := q.close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	q := newSendQueue(1, func(_ int, done <-chan struct{}) { <-done })
+	q.enqueue(1)
+	q.enqueue(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_ = q.close(ctx)
+
+	select {
+	case <-q.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("sending goroutine was not stopped")
+	}
+	if q.enqueue(3) {
+		t.Error("enqueue succeeded after close")
+	}
+	if !q.flush(context.Background()) {
+		t.Error("flush of a closed queue failed")
+	}
+}
@@ -0,0 +1,301 @@
+package log
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const DefaultRedactMask = "[REDACTED]"
+
+var (
+	// DefaultRedactKeys are always redacted. A field is redacted when its
+	// lowercased key contains one of the entries.
+	DefaultRedactKeys = []string{"password", "passwd", "secret", "token", "dsn", "authorization", "api_key", "apikey"}
+
+	// DefaultRedactPatterns mask credentials inside string values: key=value
+	// connection strings and user:password@ in URLs.
+	DefaultRedactPatterns = []string{
+		`(?i)\bpassword=('[^']*'|\S+)`,
+		`://[^:/@\s]+:([^@\s]+)@`,
+	}
+)
+
+// RedactConfig extends the default deny-list of keys and value patterns.
+// When a pattern has a capturing group only the first group is masked,
+// otherwise the whole match is.
+type RedactConfig struct {
+	Keys     []string `mapstructure:"keys"`
+	Patterns []string `mapstructure:"patterns"`
+	Mask     string   `mapstructure:"mask"`
+}
+
+// Redactor is implemented by values that know how to hide their secrets.
+// Redact returns the value to log instead of the receiver.
+type Redactor interface {
+	Redact() any
+}
+
+type redactor struct {
+	keys     []string
+	patterns []*regexp.Regexp
+	mask     string
+}
+
+func newRedactor(cfg RedactConfig) (*redactor, error) {
+	r := &redactor{mask: cfg.Mask}
+	if r.mask == "" {
+		r.mask = DefaultRedactMask
+	}
+
+	for _, key := range append(append([]string{}, DefaultRedactKeys...), cfg.Keys...) {
+		r.keys = addStr(r.keys, strings.ToLower(key))
+	}
+	for _, pattern := range append(append([]string{}, DefaultRedactPatterns...), cfg.Patterns...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+func (r *redactor) deniedKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range r.keys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String masks every pattern match in s.
+func (r *redactor) String(s string) string {
+	for _, re := range r.patterns {
+		if re.NumSubexp() == 0 {
+			s = re.ReplaceAllLiteralString(s, r.mask)
+			continue
+		}
+		s = re.ReplaceAllStringFunc(s, func(match string) string {
+			loc := re.FindStringSubmatchIndex(match)
+			if len(loc) < 4 || loc[2] < 0 {
+				return r.mask
+			}
+			return match[:loc[2]] + r.mask + match[loc[3]:]
+		})
+	}
+
+	return s
+}
+
+// Value returns a redacted copy of v.
+func (r *redactor) Value(v any) any {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case Redactor:
+		redacted := val.Redact()
+		if err, ok := v.(error); ok {
+			// Errors stay errors, so they are still logged and matched as such.
+			if _, ok = redacted.(error); !ok {
+				return &redactedError{err: err, msg: fmt.Sprint(redacted)}
+			}
+		}
+		return redacted
+	case string:
+		return r.String(val)
+	case error:
+		return &redactedError{err: val, msg: r.String(val.Error())}
+	case Fld:
+		return Fld(r.Map(val))
+	case map[string]any:
+		return r.Map(val)
+	case []any:
+		result := make([]any, len(val))
+		for i, item := range val {
+			result[i] = r.Value(item)
+		}
+		return result
+	case SentryFld:
+		result := make(SentryFld, len(val))
+		for k, s := range val {
+			if r.deniedKey(k) {
+				result[k] = r.mask
+				continue
+			}
+			result[k] = r.String(s)
+		}
+		return result
+	case map[string]string:
+		result := make(map[string]string, len(val))
+		for k, s := range val {
+			if r.deniedKey(k) {
+				result[k] = r.mask
+				continue
+			}
+			result[k] = r.String(s)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// Map returns a redacted copy of m.
+func (r *redactor) Map(m map[string]any) map[string]any {
+	result := make(map[string]any, len(m))
+	for k, v := range m {
+		if r.deniedKey(k) {
+			result[k] = r.mask
+			continue
+		}
+		result[k] = r.Value(v)
+	}
+
+	return result
+}
+
+// Fields returns fields with denied keys and matching values masked.
+// Fields needing no change are passed through without allocation.
+func (r *redactor) Fields(fields []zapcore.Field) []zapcore.Field {
+	var result []zapcore.Field
+	for i, f := range fields {
+		redacted, changed := r.Field(f)
+		if changed && result == nil {
+			result = make([]zapcore.Field, len(fields))
+			copy(result, fields[:i])
+		}
+		if result != nil {
+			result[i] = redacted
+		}
+	}
+	if result == nil {
+		return fields
+	}
+
+	return result
+}
+
+func (r *redactor) Field(f zapcore.Field) (zapcore.Field, bool) {
+	if f.Key != "" && f.Type != zapcore.NamespaceType && r.deniedKey(f.Key) {
+		return zap.String(f.Key, r.mask), true
+	}
+
+	switch f.Type {
+	case zapcore.StringType:
+		if s := r.String(f.String); s != f.String {
+			return zap.String(f.Key, s), true
+		}
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && err != nil {
+			if redacted, ok := r.Value(err).(error); ok && redacted != nil {
+				return zap.NamedError(f.Key, redacted), true
+			}
+			return zap.String(f.Key, r.mask), true
+		}
+	case zapcore.ReflectType, zapcore.StringerType, zapcore.ObjectMarshalerType, zapcore.ArrayMarshalerType:
+		switch f.Interface.(type) {
+		case Redactor, Fld, map[string]any, SentryFld, map[string]string:
+			return zap.Any(f.Key, r.Value(f.Interface)), true
+		}
+		if f.Type == zapcore.ObjectMarshalerType || f.Type == zapcore.ArrayMarshalerType {
+			return r.marshaler(f), true
+		}
+	case zapcore.InlineMarshalerType:
+		return r.marshaler(f), true
+	}
+
+	return f, false
+}
+
+// marshaler encodes an object or array field, e.g. a slog group, and redacts the
+// result, since its nested keys and values are not visible otherwise.
+func (r *redactor) marshaler(f zapcore.Field) zapcore.Field {
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+
+	switch f.Type {
+	case zapcore.InlineMarshalerType:
+		return zap.Inline(redactedObject(r.Map(enc.Fields)))
+	case zapcore.ObjectMarshalerType:
+		if m, ok := enc.Fields[f.Key].(map[string]any); ok {
+			return zap.Object(f.Key, redactedObject(r.Map(m)))
+		}
+	}
+
+	return zap.Any(f.Key, r.Value(enc.Fields[f.Key]))
+}
+
+// redactedObject is an encoded object after redaction, its keys are written in order.
+type redactedObject map[string]any
+
+func (o redactedObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	keys := make([]string, 0, len(o))
+	for k := range o {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if nested, ok := o[k].(map[string]any); ok {
+			if err := enc.AddObject(k, redactedObject(nested)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := enc.AddReflected(k, o[k]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redactedError keeps the error chain for errors.Is/As while hiding secrets in the message.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactCore masks secrets in messages and fields before they reach the wrapped core.
+type redactCore struct {
+	zapcore.Core
+	redactor *redactor
+}
+
+func newRedactCore(core zapcore.Core, r *redactor) zapcore.Core {
+	return &redactCore{Core: core, redactor: r}
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.redactor.Fields(fields)), redactor: c.redactor}
+}
+
+func (c *redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Core.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *redactCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = c.redactor.String(ent.Message)
+
+	return c.Core.Write(ent, c.redactor.Fields(fields))
+}
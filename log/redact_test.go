@@ -0,0 +1,159 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// newBufferLog returns a Log writing JSON lines to the returned buffer.
+func newBufferLog(t *testing.T, cfg Config) (*Log, *bytes.Buffer) {
+	t.Helper()
+
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = "debug"
+	}
+	buf := &bytes.Buffer{}
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(defaultEncoderConfig()), zapcore.AddSync(buf), zapcore.DebugLevel)
+	l := NewWithCore(cfg, core)
+	if l == nil {
+		t.Fatal("NewWithCore returned nil")
+	}
+
+	return l, buf
+}
+
+func TestRedactFields(t *testing.T) {
+	l, buf := newBufferLog(t, Config{Redact: RedactConfig{Keys: []string{"card"}}})
+
+	l.Infow("connecting to postgres://app:hunter2@db/app",
+		"password", "pw1",
+		"card_number", "4242",
+		"dsn", "host=db password=pw2",
+		"query", "user=app password=pw3",
+		"params", Fld{"token": "pw4", "nested": map[string]any{"api_key": "pw5"}},
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "pw1", "4242", "pw2", "pw3", "pw4", "pw5"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("output contains %q: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "user=app password="+DefaultRedactMask) {
+		t.Errorf("pattern should only mask the value: %s", out)
+	}
+}
+
+func TestRedactSlogGroup(t *testing.T) {
+	l, buf := newBufferLog(t, Config{})
+
+	l.Slog().Info("x", slog.Group("db", "password", "pw", "host", "db", slog.Group("auth", "token", "tk")))
+
+	out := buf.String()
+	if strings.Contains(out, `"pw"`) || strings.Contains(out, `"tk"`) {
+		t.Fatalf("group was not redacted: %s", out)
+	}
+	if !strings.Contains(out, `"host":"db"`) {
+		t.Fatalf("group lost its other attributes: %s", out)
+	}
+}
+
+type secretObject struct{}
+
+func (secretObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("secret", "s3")
+	enc.AddString("name", "obj")
+	return nil
+}
+
+func TestRedactObjectMarshaler(t *testing.T) {
+	l, buf := newBufferLog(t, Config{})
+
+	l.loggerStd.Info("x", zap.Object("obj", secretObject{}), zap.Inline(secretObject{}))
+
+	out := buf.String()
+	if strings.Contains(out, "s3") {
+		t.Fatalf("object was not redacted: %s", out)
+	}
+	if strings.Count(out, `"name":"obj"`) != 2 {
+		t.Fatalf("object lost its other fields: %s", out)
+	}
+}
+
+var errDiskFull = errors.New("disk full")
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errDiskFull
+}
+
+func (failingWriter) Sync() error {
+	return nil
+}
+
+func TestWriteErrorsReachCaller(t *testing.T) {
+	enc := zapcore.NewJSONEncoder(defaultEncoderConfig())
+	buf := &bytes.Buffer{}
+	tee := newTee(
+		zapcore.NewCore(enc, zapcore.AddSync(buf), zapcore.DebugLevel),
+		zapcore.NewCore(enc, failingWriter{}, zapcore.DebugLevel),
+		zapcore.NewCore(enc, failingWriter{}, zapcore.ErrorLevel),
+	)
+	redactor, err := newRedactor(RedactConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	core := newMetricsCore(newRedactCore(tee, redactor), newLogMetrics())
+
+	var errOut bytes.Buffer
+	logger := zap.New(core, zap.ErrorOutput(zapcore.AddSync(&errOut)))
+	logger.Info("hello")
+
+	if !strings.Contains(buf.String(), "hello") {
+		t.Errorf("healthy output did not get the entry: %q", buf.String())
+	}
+	if got := strings.Count(errOut.String(), "write error: disk full"); got != 1 {
+		t.Errorf("error output reports %d failures, want 1 from the info output: %q", got, errOut.String())
+	}
+}
+
+// secretErr hides its message behind Redact, which returns a string.
+type secretErr struct{}
+
+func (secretErr) Error() string {
+	return "token=s3cr3t rejected"
+}
+
+func (secretErr) Redact() any {
+	return "credentials rejected"
+}
+
+func TestRedactErrorRedactor(t *testing.T) {
+	l, buf := newBufferLog(t, Config{})
+
+	l.Errorw("failed", "err", secretErr{})
+	l.WithErr(secretErr{}).Error("failed again")
+
+	out := buf.String()
+	if strings.Contains(out, "s3cr3t") {
+		t.Errorf("output contains the secret: %s", out)
+	}
+	if strings.Count(out, "credentials rejected") != 2 {
+		t.Errorf("Redact text was not logged: %s", out)
+	}
+
+	redactor, err := newRedactor(RedactConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted, ok := redactor.Value(secretErr{}).(error)
+	if !ok || !errors.Is(redacted, secretErr{}) {
+		t.Errorf("Value = %#v, want an error wrapping the original", redacted)
+	}
+}
@@ -0,0 +1,208 @@
+package log
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSuppressReportInterval = 10 * time.Second
+	throttleIdleTTL               = time.Minute
+)
+
+// SamplingConfig logs the first Initial entries of a message per Tick,
+// then every Thereafter-th one. Thereafter of 0 drops the rest of the Tick.
+type SamplingConfig struct {
+	Initial    int           `mapstructure:"initial"`
+	Thereafter int           `mapstructure:"thereafter"`
+	Tick       time.Duration `mapstructure:"tick"`
+}
+
+// RateLimitConfig is a token bucket per message template: Rate entries per second
+// with bursts of up to Burst entries. Zero Rate disables the limiter.
+type RateLimitConfig struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+type throttleKey struct {
+	level    zapcore.Level
+	template string
+}
+
+type throttleCounter struct {
+	windowStart time.Time
+	count       int
+	tokens      float64
+	lastSeen    time.Time
+	suppressed  uint64
+}
+
+// throttle samples and rate limits entries by level and message template,
+// and periodically reports how many entries it suppressed.
+type throttle struct {
+	sampling  map[zapcore.Level]SamplingConfig
+	rateLimit RateLimitConfig
+	reporter  *zap.SugaredLogger
+	interval  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	counters  map[throttleKey]*throttleCounter
+	pruned    time.Time
+	reporting bool
+}
+
+func newThrottle(cfg Config, reporter *zap.SugaredLogger) (*throttle, error) {
+	if len(cfg.Sampling) == 0 && cfg.RateLimit.Rate <= 0 {
+		return nil, nil
+	}
+
+	sampling := make(map[zapcore.Level]SamplingConfig, len(cfg.Sampling))
+	for name, sc := range cfg.Sampling {
+		lvl, err := zapcore.ParseLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		if sc.Tick <= 0 {
+			sc.Tick = time.Second
+		}
+		sampling[lvl] = sc
+	}
+
+	rateLimit := cfg.RateLimit
+	if rateLimit.Rate > 0 && rateLimit.Burst <= 0 {
+		rateLimit.Burst = 1
+	}
+
+	interval := cfg.SuppressReportInterval
+	if interval <= 0 {
+		interval = DefaultSuppressReportInterval
+	}
+
+	return &throttle{
+		sampling:  sampling,
+		rateLimit: rateLimit,
+		reporter:  reporter,
+		interval:  interval,
+		now:       time.Now,
+		counters:  map[throttleKey]*throttleCounter{},
+	}, nil
+}
+
+func (t *throttle) allow(lvl zapcore.Level, template string) bool {
+	sc, sampled := t.sampling[lvl]
+	if !sampled && t.rateLimit.Rate <= 0 {
+		return true
+	}
+
+	now := t.now()
+	key := throttleKey{level: lvl, template: template}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.pruned) > throttleIdleTTL {
+		t.prune(now)
+	}
+	c, ok := t.counters[key]
+	if !ok {
+		c = &throttleCounter{windowStart: now, tokens: float64(t.rateLimit.Burst), lastSeen: now}
+		t.counters[key] = c
+	}
+
+	if t.rateLimit.Rate > 0 {
+		c.tokens += now.Sub(c.lastSeen).Seconds() * t.rateLimit.Rate
+		if limit := float64(t.rateLimit.Burst); c.tokens > limit {
+			c.tokens = limit
+		}
+	}
+	c.lastSeen = now
+
+	if sampled {
+		if now.Sub(c.windowStart) >= sc.Tick {
+			c.windowStart = now
+			c.count = 0
+		}
+		c.count++
+		if c.count > sc.Initial && (sc.Thereafter <= 0 || (c.count-sc.Initial)%sc.Thereafter != 0) {
+			t.suppress(c)
+			return false
+		}
+	}
+
+	if t.rateLimit.Rate > 0 {
+		if c.tokens < 1 {
+			t.suppress(c)
+			return false
+		}
+		c.tokens--
+	}
+
+	return true
+}
+
+// suppress counts a suppressed entry and starts the reporter if it is not running.
+// Must be called with mu held.
+func (t *throttle) suppress(c *throttleCounter) {
+	c.suppressed++
+	if !t.reporting {
+		t.reporting = true
+		go t.report()
+	}
+}
+
+// report logs one line per message that had entries suppressed since the last report.
+// It stops after an interval without suppressed entries, so a logger that is no longer
+// used does not keep a goroutine.
+func (t *throttle) report() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		suppressed := t.collect()
+		for key, n := range suppressed {
+			t.reporter.Warnw(
+				"suppressed similar messages",
+				"suppressed", n,
+				"suppressed_level", key.level.String(),
+				"suppressed_message", key.template,
+			)
+		}
+		if len(suppressed) == 0 {
+			return
+		}
+	}
+}
+
+// collect resets suppressed counters. When there is nothing to report it marks
+// the reporter as stopped, the next suppressed entry starts a new one.
+func (t *throttle) collect() map[throttleKey]uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := map[throttleKey]uint64{}
+	for key, c := range t.counters {
+		if c.suppressed > 0 {
+			result[key] = c.suppressed
+			c.suppressed = 0
+		}
+	}
+	if len(result) == 0 {
+		t.reporting = false
+	}
+
+	return result
+}
+
+// prune forgets messages that were not seen recently. Must be called with mu held.
+func (t *throttle) prune(now time.Time) {
+	for key, c := range t.counters {
+		if c.suppressed == 0 && now.Sub(c.lastSeen) > throttleIdleTTL {
+			delete(t.counters, key)
+		}
+	}
+	t.pruned = now
+}
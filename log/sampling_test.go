@@ -0,0 +1,143 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"go.uber.org/zap/zapcore"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for throttle.now.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestThrottle(t *testing.T, cfg Config) (*throttle, *fakeClock) {
+	t.Helper()
+
+	th, err := newThrottle(cfg, Default().logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Now()}
+	th.now = clock.now
+
+	return th, clock
+}
+
+func allowed(th *throttle, lvl zapcore.Level, template string, n int) int {
+	count := 0
+	for i := 0; i < n; i++ {
+		if th.allow(lvl, template) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestThrottleDisabled(t *testing.T) {
+	th, err := newThrottle(Config{}, nil)
+	if err != nil || th != nil {
+		t.Fatalf("newThrottle = %v, %v, want no throttle without sampling and rate limit", th, err)
+	}
+}
+
+func TestThrottleSampling(t *testing.T) {
+	th, clock := newTestThrottle(t, Config{Sampling: map[string]SamplingConfig{
+		"info":  {Initial: 2, Thereafter: 3, Tick: time.Second},
+		"debug": {Initial: 1},
+	}})
+
+	// The first 2, then every 3rd: entries 1, 2, 5 and 8.
+	if got := allowed(th, zapcore.InfoLevel, "a", 10); got != 4 {
+		t.Errorf("allowed %d of 10, want 4", got)
+	}
+	// Templates are counted separately.
+	if got := allowed(th, zapcore.InfoLevel, "b", 2); got != 2 {
+		t.Errorf("allowed %d of 2 for another template, want 2", got)
+	}
+	// Thereafter of 0 drops the rest of the tick.
+	if got := allowed(th, zapcore.DebugLevel, "a", 5); got != 1 {
+		t.Errorf("allowed %d of 5 debug entries, want 1", got)
+	}
+	// Levels without sampling are not affected.
+	if got := allowed(th, zapcore.WarnLevel, "a", 5); got != 5 {
+		t.Errorf("allowed %d of 5 warn entries, want 5", got)
+	}
+
+	clock.t = clock.t.Add(time.Second)
+	if got := allowed(th, zapcore.InfoLevel, "a", 2); got != 2 {
+		t.Errorf("allowed %d of 2 in a new tick, want 2", got)
+	}
+}
+
+func TestThrottleRateLimit(t *testing.T) {
+	th, clock := newTestThrottle(t, Config{RateLimit: RateLimitConfig{Rate: 2, Burst: 3}})
+
+	if got := allowed(th, zapcore.ErrorLevel, "a", 5); got != 3 {
+		t.Errorf("allowed %d of 5, want the burst of 3", got)
+	}
+
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	if got := allowed(th, zapcore.ErrorLevel, "a", 5); got != 1 {
+		t.Errorf("allowed %d of 5 after 0.5s, want 1", got)
+	}
+
+	// Tokens never exceed the burst.
+	clock.t = clock.t.Add(time.Hour)
+	if got := allowed(th, zapcore.ErrorLevel, "a", 5); got != 3 {
+		t.Errorf("allowed %d of 5 after an hour, want 3", got)
+	}
+}
+
+func TestThrottleReportsSuppressed(t *testing.T) {
+	l, buf := newBufferLog(t, Config{
+		LogLevel:               "info",
+		Sampling:               map[string]SamplingConfig{"info": {Initial: 1, Tick: time.Hour}},
+		SuppressReportInterval: 20 * time.Millisecond,
+	})
+	if l.throttle.reporting {
+		t.Fatal("reporter started before anything was suppressed")
+	}
+
+	for i := 0; i < 5; i++ {
+		l.Infof("order %d created", i)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		l.throttle.mu.Lock()
+		reporting := l.throttle.reporting
+		l.throttle.mu.Unlock()
+		if !reporting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("reporter kept running with nothing to report")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var entries []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want the first one and a report: %s", len(entries), buf)
+	}
+	report := entries[1]
+	if report["message"] != "suppressed similar messages" || report["suppressed"] != float64(4) ||
+		report["suppressed_message"] != "order %d created" || report["suppressed_level"] != "info" {
+		t.Errorf("report = %v", report)
+	}
+}
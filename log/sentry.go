@@ -0,0 +1,447 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sentryClientName          = "D1sordxr.packages.log/1.0"
+	sentryProtocolVersion     = "7"
+	sentryDefaultBreadcrumbs  = 100
+	sentryMaxBreadcrumbsLimit = 100
+	sentryQueueSize           = 100
+	sentryFlushTimeout        = 2 * time.Second
+	sentryRequestTimeout      = 5 * time.Second
+)
+
+// sentryDSN is a parsed Sentry DSN of the form scheme://key@host[:port]/[path/]project.
+type sentryDSN struct {
+	raw       string
+	publicKey string
+	projectID string
+	envelope  string
+}
+
+func parseSentryDSN(dsn string) (*sentryDSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sentry dsn: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid sentry dsn: unsupported scheme %q", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("invalid sentry dsn: missing public key")
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	idx := strings.LastIndex(path, "/")
+	if idx < 0 || path[idx+1:] == "" {
+		return nil, errors.New("invalid sentry dsn: missing project id")
+	}
+
+	return &sentryDSN{
+		raw:       dsn,
+		publicKey: u.User.Username(),
+		projectID: path[idx+1:],
+		envelope:  fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, path[:idx], path[idx+1:]),
+	}, nil
+}
+
+func (d *sentryDSN) authHeader() string {
+	return fmt.Sprintf(
+		"Sentry sentry_version=%s, sentry_client=%s, sentry_key=%s",
+		sentryProtocolVersion, sentryClientName, d.publicKey,
+	)
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sentryBreadcrumb struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Category  string         `json:"category,omitempty"`
+	Level     string         `json:"level"`
+	Message   string         `json:"message"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+type sentryEvent struct {
+	EventID    string            `json:"event_id"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Level      string            `json:"level"`
+	Platform   string            `json:"platform"`
+	Logger     string            `json:"logger,omitempty"`
+	ServerName string            `json:"server_name,omitempty"`
+	Culprit    string            `json:"culprit,omitempty"`
+	Message    string            `json:"message"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Extra      map[string]any    `json:"extra,omitempty"`
+	Exception  *struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception,omitempty"`
+	Breadcrumbs *struct {
+		Values []sentryBreadcrumb `json:"values"`
+	} `json:"breadcrumbs,omitempty"`
+}
+
+// sentryClient sends events to Sentry using the envelope endpoint.
+// Events are delivered by a background worker, except for levels above
+// Error which are sent synchronously so they survive panic and exit.
+type sentryClient struct {
+	dsn        *sentryDSN
+	httpClient *http.Client
+	serverName string
+
+	breadcrumbsOn  bool
+	maxBreadcrumbs int
+	mu             sync.Mutex
+	breadcrumbs    []sentryBreadcrumb
+
+	queue *sendQueue[[]byte]
+}
+
+func newSentryClient(cfg Config) (*sentryClient, error) {
+	dsn, err := parseSentryDSN(cfg.SentryDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBreadcrumbs := cfg.SentryMaxBreadcrumbs
+	if maxBreadcrumbs <= 0 {
+		maxBreadcrumbs = sentryDefaultBreadcrumbs
+	}
+	if maxBreadcrumbs > sentryMaxBreadcrumbsLimit {
+		maxBreadcrumbs = sentryMaxBreadcrumbsLimit
+	}
+
+	hostname, _ := os.Hostname()
+
+	c := &sentryClient{
+		dsn:            dsn,
+		httpClient:     &http.Client{Timeout: sentryRequestTimeout},
+		serverName:     hostname,
+		breadcrumbsOn:  cfg.SentryEnableBreadcrumbs,
+		maxBreadcrumbs: maxBreadcrumbs,
+	}
+	c.queue = newSendQueue(sentryQueueSize, func(envelope []byte, _ <-chan struct{}) {
+		_ = c.send(envelope)
+	})
+
+	return c, nil
+}
+
+// addBreadcrumb stores a breadcrumb in the ring, evicting the oldest one when full.
+func (c *sentryClient) addBreadcrumb(b sentryBreadcrumb) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.breadcrumbs) >= c.maxBreadcrumbs {
+		copy(c.breadcrumbs, c.breadcrumbs[1:])
+		c.breadcrumbs = c.breadcrumbs[:len(c.breadcrumbs)-1]
+	}
+	c.breadcrumbs = append(c.breadcrumbs, b)
+}
+
+func (c *sentryClient) snapshotBreadcrumbs() []sentryBreadcrumb {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.breadcrumbs) == 0 {
+		return nil
+	}
+	result := make([]sentryBreadcrumb, len(c.breadcrumbs))
+	copy(result, c.breadcrumbs)
+
+	return result
+}
+
+// capture encodes the event and either queues or sends it.
+func (c *sentryClient) capture(event *sentryEvent, sync bool) error {
+	envelope, err := c.envelope(event)
+	if err != nil {
+		return err
+	}
+
+	if sync {
+		return c.send(envelope)
+	}
+
+	if !c.queue.enqueue(envelope) {
+		return errors.New("sentry queue is full, event dropped")
+	}
+
+	return nil
+}
+
+func (c *sentryClient) envelope(event *sentryEvent) ([]byte, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sentry event: %w", err)
+	}
+	header, err := json.Marshal(map[string]any{
+		"event_id": event.EventID,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+		"dsn":      c.dsn.raw,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sentry envelope: %w", err)
+	}
+	itemHeader, err := json.Marshal(map[string]any{
+		"type":   "event",
+		"length": len(payload),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sentry envelope: %w", err)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(header)+len(itemHeader)+len(payload)+3))
+	buf.Write(header)
+	buf.WriteByte('\n')
+	buf.Write(itemHeader)
+	buf.WriteByte('\n')
+	buf.Write(payload)
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
+
+func (c *sentryClient) send(envelope []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sentryRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.dsn.envelope, bytes.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", c.dsn.authHeader())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send sentry event: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("sentry responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// flush waits for queued events to be delivered or for the timeout to expire.
+func (c *sentryClient) flush(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if !c.queue.flush(ctx) {
+		return errors.New("sentry flush timed out")
+	}
+
+	return nil
+}
+
+// Close sends the queued events until ctx is done and stops the sending goroutine.
+func (c *sentryClient) Close(ctx context.Context) error {
+	if err := c.queue.close(ctx); err != nil {
+		return fmt.Errorf("sentry: %w", err)
+	}
+
+	return nil
+}
+
+// sentryCore is a zapcore.Core that turns Error and above entries into Sentry
+// events and, if enabled, keeps lower level entries as breadcrumbs.
+type sentryCore struct {
+	client  *sentryClient
+	tagKeys map[string]struct{}
+	fields  []zapcore.Field
+}
+
+func newSentryCore(cfg Config) (*sentryCore, error) {
+	client, err := newSentryClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tagKeys := make(map[string]struct{}, len(cfg.ContextLogFields))
+	for _, key := range cfg.ContextLogFields {
+		tagKeys[key] = struct{}{}
+	}
+
+	return &sentryCore{client: client, tagKeys: tagKeys}, nil
+}
+
+func (c *sentryCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= zapcore.ErrorLevel || c.client.breadcrumbsOn
+}
+
+func (c *sentryCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+
+	return &clone
+}
+
+func (c *sentryCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *sentryCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	// slog records may have no time, Sentry needs one.
+	if ent.Time.IsZero() {
+		ent.Time = time.Now()
+	}
+	all := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	all = append(all, c.fields...)
+	all = append(all, fields...)
+
+	if ent.Level < zapcore.ErrorLevel {
+		c.client.addBreadcrumb(sentryBreadcrumb{
+			Timestamp: ent.Time.UTC(),
+			Category:  breadcrumbCategory(ent),
+			Level:     sentryLevel(ent.Level),
+			Message:   ent.Message,
+			Data:      encodeFields(all),
+		})
+		return nil
+	}
+
+	event := c.buildEvent(ent, all)
+
+	return c.client.capture(event, ent.Level > zapcore.ErrorLevel)
+}
+
+func (c *sentryCore) Sync() error {
+	return c.client.flush(sentryFlushTimeout)
+}
+
+func (c *sentryCore) buildEvent(ent zapcore.Entry, fields []zapcore.Field) *sentryEvent {
+	event := &sentryEvent{
+		EventID:    strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Timestamp:  ent.Time.UTC(),
+		Level:      sentryLevel(ent.Level),
+		Platform:   "go",
+		Logger:     ent.LoggerName,
+		ServerName: c.client.serverName,
+		Message:    ent.Message,
+		Tags:       map[string]string{},
+		Extra:      map[string]any{},
+	}
+	if ent.Caller.Defined {
+		event.Culprit = ent.Caller.TrimmedPath()
+	}
+
+	var exceptions []sentryException
+	rest := make([]zapcore.Field, 0, len(fields))
+	for _, f := range fields {
+		switch {
+		case f.Type == zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok && err != nil {
+				exceptions = append(exceptions, sentryException{Type: errorType(err), Value: err.Error()})
+			}
+		case f.Interface != nil:
+			if tags, ok := f.Interface.(SentryFld); ok {
+				for k, v := range tags {
+					event.Tags[k] = v
+				}
+				continue
+			}
+			rest = append(rest, f)
+		default:
+			rest = append(rest, f)
+		}
+	}
+
+	for k, v := range encodeFields(rest) {
+		if _, ok := c.tagKeys[k]; ok {
+			event.Tags[k] = fmt.Sprint(v)
+			continue
+		}
+		event.Extra[k] = v
+	}
+
+	if len(exceptions) > 0 {
+		event.Exception = &struct {
+			Values []sentryException `json:"values"`
+		}{Values: exceptions}
+	}
+	if breadcrumbs := c.client.snapshotBreadcrumbs(); breadcrumbs != nil {
+		event.Breadcrumbs = &struct {
+			Values []sentryBreadcrumb `json:"values"`
+		}{Values: breadcrumbs}
+	}
+
+	return event
+}
+
+func encodeFields(fields []zapcore.Field) map[string]any {
+	if len(fields) == 0 {
+		return nil
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	return enc.Fields
+}
+
+// errorType reports the type of the innermost error, which is more useful
+// for grouping in Sentry than wrapper types.
+func errorType(err error) string {
+	if e, ok := err.(errWithFields); ok {
+		err = e.Origin()
+	}
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return fmt.Sprintf("%T", err)
+		}
+		err = next
+	}
+}
+
+func breadcrumbCategory(ent zapcore.Entry) string {
+	if ent.LoggerName != "" {
+		return ent.LoggerName
+	}
+
+	return "log"
+}
+
+func sentryLevel(lvl zapcore.Level) string {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return "debug"
+	case zapcore.InfoLevel:
+		return "info"
+	case zapcore.WarnLevel:
+		return "warning"
+	case zapcore.ErrorLevel:
+		return "error"
+	default:
+		return "fatal"
+	}
+}
@@ -0,0 +1,207 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentryStandIn records the envelopes posted to a local Sentry endpoint.
+type sentryStandIn struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	paths     []string
+	auth      []string
+	envelopes [][]byte
+}
+
+func newSentryStandIn(t *testing.T) *sentryStandIn {
+	t.Helper()
+
+	s := &sentryStandIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.paths = append(s.paths, r.URL.Path)
+		s.auth = append(s.auth, r.Header.Get("X-Sentry-Auth"))
+		s.envelopes = append(s.envelopes, body)
+		s.mu.Unlock()
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *sentryStandIn) dsn(project string) string {
+	return strings.Replace(s.URL, "http://", "http://public@", 1) + "/" + project
+}
+
+func (s *sentryStandIn) received() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([][]byte(nil), s.envelopes...)
+}
+
+func TestParseSentryDSN(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		envelope string
+		wantErr  bool
+	}{
+		{dsn: "https://key@o1.ingest.sentry.io/42", envelope: "https://o1.ingest.sentry.io/api/42/envelope/"},
+		{dsn: "http://key@localhost:9000/sentry/7/", envelope: "http://localhost:9000/sentry/api/7/envelope/"},
+		{dsn: "ftp://key@host/1", wantErr: true},
+		{dsn: "https://host/1", wantErr: true},
+		{dsn: "https://key@host/", wantErr: true},
+		{dsn: "://bad", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			dsn, err := parseSentryDSN(tt.dsn)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", dsn)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dsn.envelope != tt.envelope {
+				t.Errorf("envelope = %q, want %q", dsn.envelope, tt.envelope)
+			}
+			if dsn.publicKey != "key" {
+				t.Errorf("publicKey = %q", dsn.publicKey)
+			}
+		})
+	}
+}
+
+func TestSentryEnvelope(t *testing.T) {
+	srv := newSentryStandIn(t)
+	l := New(Config{
+		LogLevel:                "debug",
+		OutputPaths:             []string{filepath.Join(t.TempDir(), "app.log")},
+		SentryDSN:               srv.dsn("42"),
+		SentryEnableBreadcrumbs: true,
+	})
+	if l == nil {
+		t.Fatal("New returned nil")
+	}
+
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	l.WithCtx(ctx).Infow("loading order", "order_id", 7)
+	err := Wrap("load order", errors.New("connection reset"), Fld{"table": "orders"})
+	l.ErrWithError(ctx, err, "request failed")
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	envelopes := srv.received()
+	if len(envelopes) != 1 {
+		t.Fatalf("received %d envelopes, want 1", len(envelopes))
+	}
+	if srv.paths[0] != "/api/42/envelope/" {
+		t.Errorf("posted to %q", srv.paths[0])
+	}
+	if !strings.Contains(srv.auth[0], "sentry_key=public") {
+		t.Errorf("X-Sentry-Auth = %q", srv.auth[0])
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(envelopes[0], []byte("\n")), []byte("\n"))
+	if len(lines) != 3 {
+		t.Fatalf("envelope has %d lines, want 3: %s", len(lines), envelopes[0])
+	}
+	var header, item struct {
+		EventID string `json:"event_id"`
+		DSN     string `json:"dsn"`
+		Type    string `json:"type"`
+		Length  int    `json:"length"`
+	}
+	var event sentryEvent
+	for i, v := range []any{&header, &item, &event} {
+		if err := json.Unmarshal(lines[i], v); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+	}
+
+	if header.EventID == "" || header.EventID != event.EventID || header.DSN != srv.dsn("42") {
+		t.Errorf("envelope header = %+v, event id %q", header, event.EventID)
+	}
+	if item.Type != "event" || item.Length != len(lines[2]) {
+		t.Errorf("item header = %+v, payload length %d", item, len(lines[2]))
+	}
+	if event.Level != "error" || event.Message != "request failed" {
+		t.Errorf("event level %q, message %q", event.Level, event.Message)
+	}
+	if event.Exception == nil || len(event.Exception.Values) != 1 ||
+		event.Exception.Values[0].Value != "load order: connection reset" || event.Exception.Values[0].Type != "*errors.errorString" {
+		t.Errorf("exception = %+v", event.Exception)
+	}
+	if event.Tags[RequestIDField] != "req-1" {
+		t.Errorf("tags = %v, want %s as a tag", event.Tags, RequestIDField)
+	}
+	if _, ok := event.Extra[RequestIDField]; ok {
+		t.Errorf("%s must not be repeated in extra", RequestIDField)
+	}
+	if event.Extra["table"] != "orders" {
+		t.Errorf("extra = %v, want the FieldsError fields", event.Extra)
+	}
+	if event.Breadcrumbs == nil || len(event.Breadcrumbs.Values) != 1 || event.Breadcrumbs.Values[0].Message != "loading order" {
+		t.Errorf("breadcrumbs = %+v", event.Breadcrumbs)
+	}
+}
+
+func TestSentryBreadcrumbRing(t *testing.T) {
+	client, err := newSentryClient(Config{SentryDSN: "http://key@localhost/1", SentryMaxBreadcrumbs: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"1", "2", "3", "4", "5"} {
+		client.addBreadcrumb(sentryBreadcrumb{Timestamp: time.Now(), Message: msg})
+	}
+
+	var got []string
+	for _, b := range client.snapshotBreadcrumbs() {
+		got = append(got, b.Message)
+	}
+	if strings.Join(got, ",") != "3,4,5" {
+		t.Fatalf("breadcrumbs = %v, want the last 3 in order", got)
+	}
+}
+
+func TestSentryBreadcrumbsDisabled(t *testing.T) {
+	srv := newSentryStandIn(t)
+	l := New(Config{
+		LogLevel:    "debug",
+		OutputPaths: []string{filepath.Join(t.TempDir(), "app.log")},
+		SentryDSN:   srv.dsn("1"),
+	})
+
+	l.Info("not a breadcrumb")
+	l.Warn("not sent")
+	l.Error("sent")
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	envelopes := srv.received()
+	if len(envelopes) != 1 {
+		t.Fatalf("received %d envelopes, want 1", len(envelopes))
+	}
+	if bytes.Contains(envelopes[0], []byte("breadcrumbs")) {
+		t.Errorf("breadcrumbs sent while disabled: %s", envelopes[0])
+	}
+}
@@ -0,0 +1,388 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// Levels above slog.LevelError used for Panic and Fatal entries.
+const (
+	SlogLevelPanic = slog.Level(12)
+	SlogLevelFatal = slog.Level(16)
+)
+
+func zapToSlogLevel(lvl zapcore.Level) slog.Level {
+	switch {
+	case lvl <= zapcore.DebugLevel:
+		return slog.LevelDebug
+	case lvl == zapcore.InfoLevel:
+		return slog.LevelInfo
+	case lvl == zapcore.WarnLevel:
+		return slog.LevelWarn
+	case lvl == zapcore.ErrorLevel:
+		return slog.LevelError
+	case lvl == zapcore.FatalLevel:
+		return SlogLevelFatal
+	default:
+		return SlogLevelPanic
+	}
+}
+
+func slogToZapLevel(lvl slog.Level) zapcore.Level {
+	switch {
+	case lvl < slog.LevelInfo:
+		return zapcore.DebugLevel
+	case lvl < slog.LevelWarn:
+		return zapcore.InfoLevel
+	case lvl < slog.LevelError:
+		return zapcore.WarnLevel
+	case lvl < SlogLevelPanic:
+		return zapcore.ErrorLevel
+	case lvl < SlogLevelFatal:
+		return zapcore.PanicLevel
+	default:
+		return zapcore.FatalLevel
+	}
+}
+
+// Slog returns a *slog.Logger writing through l.
+func (l *Log) Slog() *slog.Logger {
+	return slog.New(l.Handler())
+}
+
+// Handler returns an slog.Handler writing through l. Records are logged with the
+// context fields of the ctx passed to the slog call, like WithCtx does.
+func (l *Log) Handler() slog.Handler {
+	return &slogHandler{log: l}
+}
+
+// slogHandler is an slog.Handler over Log. Groups are mapped to zap namespaces;
+// a group is only opened once it has attributes, as slog requires.
+type slogHandler struct {
+	log     *Log
+	fields  []zapcore.Field
+	pending []string
+}
+
+func (h *slogHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	zl := slogToZapLevel(lvl)
+	if h.log.loggerStd.Core().Enabled(zl) {
+		return true
+	}
+
+	return ctx != nil && zl == zapcore.DebugLevel && DebugFromContext(ctx)
+}
+
+func (h *slogHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	zl := slogToZapLevel(rec.Level)
+	if !h.log.allow(zl, rec.Message) {
+		return nil
+	}
+
+	// A zero time is kept, encoders omit it as slog.Handler requires.
+	logger := h.log.WithCtx(ctx)
+	ent := zapcore.Entry{
+		LoggerName: logger.loggerStd.Name(),
+		Level:      zl,
+		Time:       rec.Time,
+		Message:    rec.Message,
+	}
+	if rec.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{rec.PC}).Next()
+		ent.Caller = zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true)
+		ent.Caller.Function = frame.Function
+	}
+
+	ce := logger.loggerStd.Core().Check(ent, nil)
+	if ce == nil {
+		return nil
+	}
+
+	fields := make([]zapcore.Field, 0, len(h.fields)+len(h.pending)+rec.NumAttrs())
+	fields = append(fields, h.fields...)
+	attrs := make([]zapcore.Field, 0, rec.NumAttrs())
+	rec.Attrs(func(a slog.Attr) bool {
+		attrs = appendAttr(attrs, a)
+		return true
+	})
+	if len(attrs) > 0 {
+		for _, g := range h.pending {
+			fields = append(fields, zap.Namespace(g))
+		}
+		fields = append(fields, attrs...)
+	}
+	ce.Write(fields...)
+
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	converted := make([]zapcore.Field, 0, len(attrs))
+	for _, a := range attrs {
+		converted = appendAttr(converted, a)
+	}
+	if len(converted) == 0 {
+		return h
+	}
+
+	clone := &slogHandler{log: h.log}
+	clone.fields = make([]zapcore.Field, 0, len(h.fields)+len(h.pending)+len(converted))
+	clone.fields = append(clone.fields, h.fields...)
+	for _, g := range h.pending {
+		clone.fields = append(clone.fields, zap.Namespace(g))
+	}
+	clone.fields = append(clone.fields, converted...)
+
+	return clone
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	clone := &slogHandler{log: h.log, fields: h.fields}
+	clone.pending = make([]string, 0, len(h.pending)+1)
+	clone.pending = append(clone.pending, h.pending...)
+	clone.pending = append(clone.pending, name)
+
+	return clone
+}
+
+// appendAttr converts a resolved slog attribute to zap fields.
+// Groups with an empty key are inlined and empty groups are dropped.
+func appendAttr(fields []zapcore.Field, a slog.Attr) []zapcore.Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		group := a.Value.Group()
+		if len(group) == 0 {
+			return fields
+		}
+		if a.Key == "" {
+			for _, ga := range group {
+				fields = appendAttr(fields, ga)
+			}
+			return fields
+		}
+		return append(fields, zap.Object(a.Key, slogGroup(group)))
+	case slog.KindString:
+		return append(fields, zap.String(a.Key, a.Value.String()))
+	case slog.KindInt64:
+		return append(fields, zap.Int64(a.Key, a.Value.Int64()))
+	case slog.KindUint64:
+		return append(fields, zap.Uint64(a.Key, a.Value.Uint64()))
+	case slog.KindFloat64:
+		return append(fields, zap.Float64(a.Key, a.Value.Float64()))
+	case slog.KindBool:
+		return append(fields, zap.Bool(a.Key, a.Value.Bool()))
+	case slog.KindDuration:
+		return append(fields, zap.Duration(a.Key, a.Value.Duration()))
+	case slog.KindTime:
+		return append(fields, zap.Time(a.Key, a.Value.Time()))
+	default:
+		return append(fields, zap.Any(a.Key, a.Value.Any()))
+	}
+}
+
+type slogGroup []slog.Attr
+
+func (g slogGroup) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, f := range appendAttr(nil, slog.Attr{Value: slog.GroupValue(g...)}) {
+		f.AddTo(enc)
+	}
+
+	return nil
+}
+
+// SlogLogger is a Logger writing to any slog.Handler.
+type SlogLogger struct {
+	handler slog.Handler
+}
+
+var _ Logger = (*SlogLogger)(nil)
+
+// NewSlogLogger creates a Logger over h.
+func NewSlogLogger(h slog.Handler) *SlogLogger {
+	return &SlogLogger{handler: h}
+}
+
+// Handler returns the underlying slog.Handler.
+func (l *SlogLogger) Handler() slog.Handler {
+	return l.handler
+}
+
+// With returns a SlogLogger adding fld to every record.
+func (l *SlogLogger) With(fld Fld) *SlogLogger {
+	return &SlogLogger{handler: l.handler.WithAttrs(fldAttrs(fld))}
+}
+
+// WithErr returns a SlogLogger adding err and its fields to every record.
+func (l *SlogLogger) WithErr(err error) *SlogLogger {
+	return &SlogLogger{handler: l.handler.WithAttrs(errAttrs(err))}
+}
+
+func (l *SlogLogger) Info(msg string) {
+	l.log(context.Background(), slog.LevelInfo, msg, nil)
+}
+
+func (l *SlogLogger) Infof(msg string, args ...interface{}) {
+	l.log(context.Background(), slog.LevelInfo, fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *SlogLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.log(context.Background(), slog.LevelInfo, msg, keysAndValues)
+}
+
+func (l *SlogLogger) Debug(msg string) {
+	l.log(context.Background(), slog.LevelDebug, msg, nil)
+}
+
+func (l *SlogLogger) Debugf(msg string, args ...interface{}) {
+	l.log(context.Background(), slog.LevelDebug, fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *SlogLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.log(context.Background(), slog.LevelDebug, msg, keysAndValues)
+}
+
+func (l *SlogLogger) Warn(msg string) {
+	l.log(context.Background(), slog.LevelWarn, msg, nil)
+}
+
+func (l *SlogLogger) Warnf(msg string, args ...interface{}) {
+	l.log(context.Background(), slog.LevelWarn, fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *SlogLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.log(context.Background(), slog.LevelWarn, msg, keysAndValues)
+}
+
+func (l *SlogLogger) Error(msg string) {
+	l.log(context.Background(), slog.LevelError, msg, nil)
+}
+
+func (l *SlogLogger) Errorf(msg string, args ...interface{}) {
+	l.log(context.Background(), slog.LevelError, fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *SlogLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.log(context.Background(), slog.LevelError, msg, keysAndValues)
+}
+
+// Panic logs msg at SlogLevelPanic and panics.
+func (l *SlogLogger) Panic(msg string) {
+	l.log(context.Background(), SlogLevelPanic, msg, nil)
+	panic(msg)
+}
+
+// Fatal logs msg at SlogLevelFatal, flushes registered flushers and exits the process.
+func (l *SlogLogger) Fatal(msg string) {
+	l.fatal(msg, nil)
+}
+
+func (l *SlogLogger) Fatalf(msg string, args ...interface{}) {
+	l.fatal(fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *SlogLogger) Fatalw(msg string, keysAndValues ...interface{}) {
+	l.fatal(msg, keysAndValues)
+}
+
+func (l *SlogLogger) ErrWithWarn(ctx context.Context, err error, msg string) {
+	l.log(ctx, slog.LevelWarn, msg, nil, errAttrs(err)...)
+}
+
+func (l *SlogLogger) ErrWithWarnf(ctx context.Context, err error, msg string, args ...interface{}) {
+	l.log(ctx, slog.LevelWarn, fmt.Sprintf(msg, args...), nil, errAttrs(err)...)
+}
+
+func (l *SlogLogger) ErrWithWarnw(ctx context.Context, err error, msg string, keysAndValues ...interface{}) {
+	l.log(ctx, slog.LevelWarn, msg, keysAndValues, errAttrs(err)...)
+}
+
+func (l *SlogLogger) ErrWithError(ctx context.Context, err error, msg string) {
+	l.log(ctx, slog.LevelError, msg, nil, errAttrs(err)...)
+}
+
+func (l *SlogLogger) ErrWithErrorf(ctx context.Context, err error, msg string, args ...interface{}) {
+	l.log(ctx, slog.LevelError, fmt.Sprintf(msg, args...), nil, errAttrs(err)...)
+}
+
+func (l *SlogLogger) ErrWithErrorw(ctx context.Context, err error, msg string, keysAndValues ...interface{}) {
+	l.log(ctx, slog.LevelError, msg, keysAndValues, errAttrs(err)...)
+}
+
+func (l *SlogLogger) LogGRPC(ctx context.Context, lvl logging.Level, msg string, fields ...any) {
+	l.log(ctx, slog.Level(lvl), msg, fields)
+}
+
+func (l *SlogLogger) fatal(msg string, keysAndValues []any) {
+	l.log(context.Background(), SlogLevelFatal, msg, keysAndValues)
+	flushAndExit(nil)
+}
+
+// log builds the record; it must be called directly by the exported methods
+// so that the caller frame is found at a fixed depth.
+func (l *SlogLogger) log(ctx context.Context, lvl slog.Level, msg string, keysAndValues []any, attrs ...slog.Attr) {
+	if !l.handler.Enabled(ctx, lvl) {
+		return
+	}
+
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+
+	rec := slog.NewRecord(time.Now(), lvl, msg, pcs[0])
+	if _, ours := l.handler.(*slogHandler); !ours {
+		rec.AddAttrs(contextAttrs(ctx)...)
+	}
+	rec.AddAttrs(attrs...)
+	rec.Add(keysAndValues...)
+
+	_ = l.handler.Handle(ctx, rec)
+}
+
+// contextAttrs returns the request id and fields of ctx for handlers that
+// do not know about this package.
+func contextAttrs(ctx context.Context) []slog.Attr {
+	var attrs []slog.Attr
+	if id, ok := RequestIDFromContext(ctx); ok {
+		attrs = append(attrs, slog.String(RequestIDField, id))
+	}
+
+	return append(attrs, fldAttrs(FieldsFromContext(ctx))...)
+}
+
+func errAttrs(err error) []slog.Attr {
+	fields := FieldsOf(err)
+	if code := CodeOf(err); code != "" {
+		fields[ErrorCodeField] = string(code)
+	}
+	if e, ok := err.(errWithFields); ok {
+		err = e.Origin()
+	}
+
+	return append([]slog.Attr{slog.Any("error", err)}, fldAttrs(fields)...)
+}
+
+func fldAttrs(fld Fld) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(fld))
+	for k, v := range fld {
+		attrs = append(attrs, slog.Any(k, v))
+	}
+
+	return attrs
+}
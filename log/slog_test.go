@@ -0,0 +1,171 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap/zapcore"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/slogtest"
+	"time"
+)
+
+func TestSlogHandlerConformance(t *testing.T) {
+	var buf *bytes.Buffer
+
+	slogtest.Run(t, func(t *testing.T) slog.Handler {
+		var l *Log
+		l, buf = newBufferLog(t, Config{})
+		return l.Handler()
+	}, func(t *testing.T) map[string]any {
+		entry := lastEntry(t, buf)
+		// slogtest expects the slog key names.
+		entry[slog.MessageKey] = entry["message"]
+		delete(entry, "message")
+		return entry
+	})
+}
+
+func TestSlogLevels(t *testing.T) {
+	tests := []struct {
+		zap  zapcore.Level
+		slog slog.Level
+	}{
+		{zap: zapcore.DebugLevel, slog: slog.LevelDebug},
+		{zap: zapcore.InfoLevel, slog: slog.LevelInfo},
+		{zap: zapcore.WarnLevel, slog: slog.LevelWarn},
+		{zap: zapcore.ErrorLevel, slog: slog.LevelError},
+		{zap: zapcore.PanicLevel, slog: SlogLevelPanic},
+		{zap: zapcore.FatalLevel, slog: SlogLevelFatal},
+	}
+	for _, tt := range tests {
+		if got := zapToSlogLevel(tt.zap); got != tt.slog {
+			t.Errorf("zapToSlogLevel(%s) = %s, want %s", tt.zap, got, tt.slog)
+		}
+		if got := slogToZapLevel(tt.slog); got != tt.zap {
+			t.Errorf("slogToZapLevel(%s) = %s, want %s", tt.slog, got, tt.zap)
+		}
+	}
+
+	// Levels between the named ones round down.
+	for lvl, want := range map[slog.Level]zapcore.Level{
+		slog.LevelDebug - 4: zapcore.DebugLevel,
+		slog.LevelInfo + 2:  zapcore.InfoLevel,
+		slog.LevelError + 1: zapcore.ErrorLevel,
+		SlogLevelFatal + 4:  zapcore.FatalLevel,
+	} {
+		if got := slogToZapLevel(lvl); got != want {
+			t.Errorf("slogToZapLevel(%s) = %s, want %s", lvl, got, want)
+		}
+	}
+}
+
+func TestSlogHandlerGroupsAndContext(t *testing.T) {
+	l, buf := newBufferLog(t, Config{LogLevel: "info"})
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+
+	logger := l.Named("orders").Slog().With("service", "shop").WithGroup("req")
+	logger.InfoContext(ctx, "handled", "status", 200, slog.Group("user", "id", 7))
+	logger.DebugContext(ctx, "filtered")
+
+	got := entries(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("got %d entries, want 1: %s", len(got), buf)
+	}
+	entry := got[0]
+	if entry["level"] != "INFO" || entry["logger"] != "orders" || entry[RequestIDField] != "req-1" || entry["service"] != "shop" {
+		t.Errorf("entry = %v", entry)
+	}
+	req, _ := entry["req"].(map[string]any)
+	user, _ := req["user"].(map[string]any)
+	if req["status"] != float64(200) || user["id"] != float64(7) {
+		t.Errorf("groups were not nested: %v", entry)
+	}
+	if caller, _ := entry["caller"].(string); !strings.HasPrefix(caller, "log/slog_test.go:") {
+		t.Errorf("caller = %q, want the slog call site", caller)
+	}
+
+	body := scrape(t, l.MetricsHandler())
+	assertMetric(t, body, `log_entries_total{level="info",logger="orders",error_code=""} 1`)
+}
+
+func TestSlogHandlerDebugContext(t *testing.T) {
+	l, buf := newBufferLog(t, Config{LogLevel: "info"})
+	ctx := context.WithValue(context.Background(), DebugField, true)
+
+	l.Slog().DebugContext(ctx, "forced")
+	l.Slog().Debug("filtered")
+
+	if got := entries(t, buf); len(got) != 1 || got[0]["message"] != "forced" {
+		t.Errorf("entries = %v, want only the debug context one", got)
+	}
+}
+
+func TestSlogLoggerOverLog(t *testing.T) {
+	l, buf := newBufferLog(t, Config{})
+	sl := NewSlogLogger(l.Handler())
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+
+	sl.Debugw("debug", "n", 1)
+	sl.Infof("info %d", 2)
+	sl.With(Fld{"order_id": 7}).Warn("warn")
+	sl.ErrWithErrorw(ctx, Wrap("load", NewError(CodeNotFound, "missing"), Fld{"table": "orders"}), "error", "attempt", 3)
+
+	got := entries(t, buf)
+	if len(got) != 4 {
+		t.Fatalf("got %d entries, want 4: %s", len(got), buf)
+	}
+	for i, want := range []struct{ level, msg string }{{"DEBUG", "debug"}, {"INFO", "info 2"}, {"WARN", "warn"}, {"ERROR", "error"}} {
+		if got[i]["level"] != want.level || got[i]["message"] != want.msg {
+			t.Errorf("entry %d = %v, want %s %q", i, got[i], want.level, want.msg)
+		}
+		if caller, _ := got[i]["caller"].(string); !strings.HasPrefix(caller, "log/slog_test.go:") {
+			t.Errorf("entry %d caller = %q, want the SlogLogger call site", i, caller)
+		}
+	}
+	if got[0]["n"] != float64(1) || got[2]["order_id"] != float64(7) {
+		t.Errorf("fields were lost: %v", got)
+	}
+	last := got[3]
+	if last["error"] != "load: missing" || last[ErrorCodeField] != "not_found" || last["table"] != "orders" ||
+		last["attempt"] != float64(3) || last[RequestIDField] != "req-1" {
+		t.Errorf("error entry = %v", last)
+	}
+}
+
+func TestSlogLoggerOverForeignHandler(t *testing.T) {
+	codes := stubExit(t)
+	var buf bytes.Buffer
+	sl := NewSlogLogger(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx := ContextWithFields(ContextWithRequestID(context.Background(), "req-1"), Fld{"tenant": "acme"})
+
+	sl.ErrWithWarn(ctx, errors.New("boom"), "warned")
+	sl.Fatalw("bye", "reason", "test")
+
+	if len(*codes) != 1 {
+		t.Fatalf("exit codes = %v, want one exit", *codes)
+	}
+	var got []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, entry)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d records, want 2: %s", len(got), buf.String())
+	}
+	if got[0]["level"] != "WARN" || got[0]["error"] != "boom" || got[0][RequestIDField] != "req-1" || got[0]["tenant"] != "acme" {
+		t.Errorf("warn record = %v", got[0])
+	}
+	if got[1]["level"] != SlogLevelFatal.String() || got[1]["reason"] != "test" {
+		t.Errorf("fatal record = %v", got[1])
+	}
+	if ts, _ := time.Parse(time.RFC3339Nano, got[1]["time"].(string)); time.Since(ts) > time.Minute {
+		t.Errorf("record time = %v", got[1]["time"])
+	}
+}
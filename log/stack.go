@@ -0,0 +1,66 @@
+package log
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	ErrorStackField = "error_stack"
+	maxStackDepth   = 32
+)
+
+var stackCapture atomic.Bool
+
+func init() {
+	stackCapture.Store(true)
+}
+
+// SetStackCapture turns stack capture in Wrap on or off. It is on by default;
+// only program counters are recorded, symbols are resolved when the stack is printed.
+func SetStackCapture(enabled bool) {
+	stackCapture.Store(enabled)
+}
+
+// stack is a list of program counters captured by callers.
+type stack []uintptr
+
+// callers records the stack above the caller of callers, skipping skip more frames.
+func callers(skip int) stack {
+	if !stackCapture.Load() {
+		return nil
+	}
+
+	var pcs [maxStackDepth]uintptr
+	n := runtime.Callers(2+skip, pcs[:])
+	s := make(stack, n)
+	copy(s, pcs[:n])
+
+	return s
+}
+
+// String formats the stack like a panic trace: function on one line, file:line on the next.
+func (s stack) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	frames := runtime.CallersFrames(s)
+	for {
+		frame, more := frames.Next()
+		b.WriteString(frame.Function)
+		b.WriteString("\n\t")
+		b.WriteString(frame.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(frame.Line))
+		if !more {
+			break
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
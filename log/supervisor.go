@@ -0,0 +1,185 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	GoroutineField = "goroutine"
+
+	DefaultBackoffInitial = 100 * time.Millisecond
+	DefaultBackoffMax     = 30 * time.Second
+)
+
+// RestartPolicy decides whether a supervised goroutine is started again after it exits.
+type RestartPolicy int
+
+const (
+	// RestartNever runs the function once.
+	RestartNever RestartPolicy = iota
+	// RestartAlways restarts the function after every exit, waiting the initial backoff delay.
+	RestartAlways
+	// RestartBackoff restarts the function after a panic or an error with an exponential delay.
+	RestartBackoff
+)
+
+type goOptions struct {
+	policy      RestartPolicy
+	initial     time.Duration
+	max         time.Duration
+	maxRestarts int
+}
+
+// GoOption configures a supervised goroutine.
+type GoOption func(*goOptions)
+
+// WithRestart sets the restart policy, RestartNever by default.
+func WithRestart(policy RestartPolicy) GoOption {
+	return func(o *goOptions) {
+		o.policy = policy
+	}
+}
+
+// WithBackoff sets the first and the maximum delay between restarts.
+func WithBackoff(initial, max time.Duration) GoOption {
+	return func(o *goOptions) {
+		o.initial = initial
+		o.max = max
+	}
+}
+
+// WithMaxRestarts stops restarting after n restarts, 0 means no limit.
+func WithMaxRestarts(n int) GoOption {
+	return func(o *goOptions) {
+		o.maxRestarts = n
+	}
+}
+
+// Supervisor runs goroutines that recover and log panics and restart according to their policy.
+type Supervisor struct {
+	logger *Log
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// NewSupervisor returns a Supervisor logging through l. Its goroutines are stopped when ctx is done.
+func NewSupervisor(ctx context.Context, l *Log) *Supervisor {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Supervisor{logger: l, ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn under a new Supervisor logging through the default logger.
+func Go(ctx context.Context, name string, fn func(ctx context.Context) error, opts ...GoOption) *Supervisor {
+	return L().Go(ctx, name, fn, opts...)
+}
+
+// Go runs fn under a new Supervisor logging through l.
+func (l *Log) Go(ctx context.Context, name string, fn func(ctx context.Context) error, opts ...GoOption) *Supervisor {
+	s := NewSupervisor(ctx, l)
+	s.Go(name, fn, opts...)
+
+	return s
+}
+
+// Go runs fn in a goroutine. Panics are logged with the context fields of the
+// supervisor context and the goroutine name, then handled by the restart policy.
+func (s *Supervisor) Go(name string, fn func(ctx context.Context) error, opts ...GoOption) {
+	o := goOptions{initial: DefaultBackoffInitial, max: DefaultBackoffMax}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		if err := s.supervise(name, fn, o); err != nil {
+			s.mu.Lock()
+			s.errs = append(s.errs, err)
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// Wait blocks until every goroutine stopped and returns the errors they stopped with.
+func (s *Supervisor) Wait() error {
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return errors.Join(s.errs...)
+}
+
+// Stop cancels the supervisor context and waits for the goroutines.
+func (s *Supervisor) Stop() error {
+	s.cancel()
+
+	return s.Wait()
+}
+
+func (s *Supervisor) supervise(name string, fn func(ctx context.Context) error, o goOptions) error {
+	logger := s.logger.WithCtx(s.ctx).WithField(GoroutineField, name)
+	delay := o.initial
+
+	for restarts := 0; ; restarts++ {
+		start := time.Now()
+		panicked, err := s.run(logger, name, fn)
+		if err != nil && !panicked && s.ctx.Err() == nil {
+			logger.WithErr(err).Error("goroutine failed")
+		}
+
+		switch {
+		case s.ctx.Err() != nil:
+			return nil
+		case o.policy == RestartNever, o.policy == RestartBackoff && err == nil:
+			return err
+		case o.maxRestarts > 0 && restarts >= o.maxRestarts:
+			logger.Errorf("goroutine stopped after %d restarts", restarts)
+			if err == nil {
+				return fmt.Errorf("%s: stopped after %d restarts", name, restarts)
+			}
+			return fmt.Errorf("%s: stopped after %d restarts: %w", name, restarts, err)
+		}
+
+		wait := o.initial
+		if o.policy == RestartBackoff {
+			// A run longer than the maximum delay counts as healthy and resets the backoff.
+			if time.Since(start) > o.max {
+				delay = o.initial
+			}
+			wait = delay
+			delay = min(delay*2, o.max)
+		}
+		logger.Warnf("restarting goroutine in %s", wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// run calls fn, turning a panic into an error after logging it.
+func (s *Supervisor) run(logger *Log, name string, fn func(ctx context.Context) error) (panicked bool, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.LogPanic(p)
+			panicked, err = true, fmt.Errorf("%s: panic: %v", name, p)
+		}
+	}()
+
+	return false, fn(s.ctx)
+}
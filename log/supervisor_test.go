@@ -0,0 +1,237 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.uber.org/zap/zapcore"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for writes from supervised goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) snapshot() *bytes.Buffer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return bytes.NewBuffer(append([]byte(nil), b.buf.Bytes()...))
+}
+
+func newSyncBufferLog(t *testing.T) (*Log, *syncBuffer) {
+	t.Helper()
+
+	buf := &syncBuffer{}
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(defaultEncoderConfig()), zapcore.AddSync(buf), zapcore.DebugLevel)
+	l := NewWithCore(Config{LogLevel: "debug"}, core)
+	if l == nil {
+		t.Fatal("NewWithCore returned nil")
+	}
+
+	return l, buf
+}
+
+// messages returns the messages logged at level.
+func messages(t *testing.T, buf *syncBuffer, level string) []string {
+	t.Helper()
+
+	var result []string
+	for _, entry := range entries(t, buf.snapshot()) {
+		if entry["level"] == level {
+			result = append(result, entry["message"].(string))
+		}
+	}
+
+	return result
+}
+
+var errJob = errors.New("job failed")
+
+func TestSupervisorRecoversPanic(t *testing.T) {
+	l, buf := newSyncBufferLog(t)
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+
+	err := l.Go(ctx, "worker", func(context.Context) error {
+		panic("boom")
+	}).Wait()
+
+	if err == nil || !strings.Contains(err.Error(), "worker: panic: boom") {
+		t.Errorf("Wait = %v, want the panic", err)
+	}
+	got := entries(t, buf.snapshot())
+	if len(got) != 1 {
+		t.Fatalf("got %d entries, want the panic only: %v", len(got), got)
+	}
+	if msg, _ := got[0]["message"].(string); got[0]["level"] != "ERROR" || !strings.HasPrefix(msg, "Panic recovered: boom") {
+		t.Errorf("entry = %v, want the recovered panic", got[0])
+	}
+	if got[0][GoroutineField] != "worker" || got[0][RequestIDField] != "req-1" {
+		t.Errorf("entry lost the goroutine or context fields: %v", got[0])
+	}
+}
+
+func TestSupervisorRestartNever(t *testing.T) {
+	l, buf := newSyncBufferLog(t)
+	var runs atomic.Int32
+
+	err := l.Go(context.Background(), "worker", func(context.Context) error {
+		runs.Add(1)
+		return errJob
+	}).Wait()
+
+	if !errors.Is(err, errJob) || runs.Load() != 1 {
+		t.Errorf("Wait = %v after %d runs, want the error after one run", err, runs.Load())
+	}
+	if errs := messages(t, buf, "ERROR"); len(errs) != 1 || errs[0] != "goroutine failed" {
+		t.Errorf("errors logged = %q", errs)
+	}
+}
+
+func TestSupervisorRestartAlways(t *testing.T) {
+	l, buf := newSyncBufferLog(t)
+	var runs atomic.Int32
+
+	err := l.Go(context.Background(), "worker", func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}, WithRestart(RestartAlways), WithBackoff(time.Millisecond, time.Second), WithMaxRestarts(3)).Wait()
+
+	if err == nil || err.Error() != "worker: stopped after 3 restarts" || runs.Load() != 4 {
+		t.Errorf("Wait = %v after %d runs, want a stop after 4 runs", err, runs.Load())
+	}
+	// Every restart waits the initial delay.
+	if warns := messages(t, buf, "WARN"); strings.Join(warns, ",") != "restarting goroutine in 1ms,restarting goroutine in 1ms,restarting goroutine in 1ms" {
+		t.Errorf("warnings = %q", warns)
+	}
+}
+
+func TestSupervisorRestartBackoff(t *testing.T) {
+	l, buf := newSyncBufferLog(t)
+	var runs atomic.Int32
+
+	err := l.Go(context.Background(), "worker", func(context.Context) error {
+		switch runs.Add(1) {
+		case 1, 2, 3:
+			return errJob
+		case 4:
+			// Longer than the maximum delay, the backoff starts over.
+			time.Sleep(20 * time.Millisecond)
+			return errJob
+		default:
+			return nil
+		}
+	}, WithRestart(RestartBackoff), WithBackoff(time.Millisecond, 4*time.Millisecond)).Wait()
+
+	if err != nil || runs.Load() != 5 {
+		t.Fatalf("Wait = %v after %d runs, want success on the 5th run", err, runs.Load())
+	}
+	want := "restarting goroutine in 1ms,restarting goroutine in 2ms,restarting goroutine in 4ms,restarting goroutine in 1ms"
+	if warns := messages(t, buf, "WARN"); strings.Join(warns, ",") != want {
+		t.Errorf("warnings = %q, want %q", warns, want)
+	}
+	if errs := messages(t, buf, "ERROR"); len(errs) != 4 {
+		t.Errorf("logged %d failures, want 4", len(errs))
+	}
+}
+
+func TestSupervisorRestartBackoffRecoversPanics(t *testing.T) {
+	l, buf := newSyncBufferLog(t)
+	var runs atomic.Int32
+
+	err := l.Go(context.Background(), "worker", func(context.Context) error {
+		if runs.Add(1) == 1 {
+			panic("boom")
+		}
+		return nil
+	}, WithRestart(RestartBackoff), WithBackoff(time.Millisecond, time.Millisecond)).Wait()
+
+	if err != nil || runs.Load() != 2 {
+		t.Errorf("Wait = %v after %d runs, want a restart after the panic", err, runs.Load())
+	}
+	// The panic is logged once, not again as a failure.
+	if errs := messages(t, buf, "ERROR"); len(errs) != 1 || !strings.HasPrefix(errs[0], "Panic recovered: boom") {
+		t.Errorf("errors logged = %q", errs)
+	}
+}
+
+func TestSupervisorMaxRestartsWrapsError(t *testing.T) {
+	l, buf := newSyncBufferLog(t)
+	var runs atomic.Int32
+
+	err := l.Go(context.Background(), "worker", func(context.Context) error {
+		runs.Add(1)
+		return errJob
+	}, WithRestart(RestartBackoff), WithBackoff(time.Millisecond, time.Millisecond), WithMaxRestarts(2)).Wait()
+
+	if !errors.Is(err, errJob) || !strings.Contains(err.Error(), "worker: stopped after 2 restarts") {
+		t.Errorf("Wait = %v, want the last error wrapped", err)
+	}
+	if runs.Load() != 3 {
+		t.Errorf("ran %d times, want 3", runs.Load())
+	}
+	if errs := messages(t, buf, "ERROR"); len(errs) != 4 || errs[3] != "goroutine stopped after 2 restarts" {
+		t.Errorf("errors logged = %q", errs)
+	}
+}
+
+func TestSupervisorStopInterruptsBackoff(t *testing.T) {
+	l, buf := newSyncBufferLog(t)
+
+	s := l.Go(context.Background(), "worker", func(context.Context) error {
+		return errJob
+	}, WithRestart(RestartBackoff), WithBackoff(time.Hour, time.Hour))
+
+	deadline := time.Now().Add(time.Second)
+	for len(messages(t, buf, "WARN")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("goroutine was not restarted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if err := s.Stop(); err != nil {
+		t.Errorf("Stop = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Stop took %v", elapsed)
+	}
+}
+
+func TestSupervisorStopCancelsContext(t *testing.T) {
+	l, buf := newSyncBufferLog(t)
+	started := make(chan struct{})
+
+	s := NewSupervisor(context.Background(), l)
+	for _, name := range []string{"a", "b"} {
+		s.Go(name, func(ctx context.Context) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		}, WithRestart(RestartAlways))
+	}
+	<-started
+	<-started
+
+	if err := s.Stop(); err != nil {
+		t.Errorf("Stop = %v, want nil", err)
+	}
+	// Errors caused by the cancellation are not failures.
+	if got := entries(t, buf.snapshot()); len(got) != 0 {
+		t.Errorf("logged %v", got)
+	}
+}
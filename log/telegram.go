@@ -0,0 +1,429 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	TgParseModeHTML       = "HTML"
+	TgParseModeMarkdown   = "Markdown"
+	TgParseModeMarkdownV2 = "MarkdownV2"
+
+	tgDefaultAPIURL       = "https://api.telegram.org"
+	tgDefaultSendInterval = time.Second
+	tgDefaultDedupWindow  = time.Minute
+	tgQueueSize           = 100
+	tgMaxMessageLen       = 4096
+	// tgReservedLen is kept free in every alert for the suppressed messages note.
+	tgReservedLen    = 64
+	tgFlushTimeout   = 5 * time.Second
+	tgRequestTimeout = 10 * time.Second
+)
+
+type tgMessage struct {
+	text string
+	key  string
+}
+
+// telegramClient delivers alert messages to a Telegram chat through the Bot API.
+// Messages are sent by a background worker no more often than sendInterval,
+// and identical messages are suppressed for dedupWindow.
+type telegramClient struct {
+	endpoint     string
+	chatID       int64
+	parseMode    string
+	sendInterval time.Duration
+	dedupWindow  time.Duration
+	httpClient   *http.Client
+
+	mu         sync.Mutex
+	seen       map[string]time.Time
+	suppressed map[string]int
+
+	sendMu   sync.Mutex
+	lastSent time.Time
+
+	queue *sendQueue[tgMessage]
+}
+
+func newTelegramClient(cfg Config) (*telegramClient, error) {
+	if cfg.TgChatID == 0 {
+		return nil, errors.New("telegram chat id is required")
+	}
+	switch cfg.TgMsgParseMode {
+	case "", TgParseModeHTML, TgParseModeMarkdown, TgParseModeMarkdownV2:
+	default:
+		return nil, fmt.Errorf("unsupported telegram parse mode %q", cfg.TgMsgParseMode)
+	}
+
+	apiURL := cfg.TgAPIURL
+	if apiURL == "" {
+		apiURL = tgDefaultAPIURL
+	}
+	sendInterval := cfg.TgSendInterval
+	if sendInterval <= 0 {
+		sendInterval = tgDefaultSendInterval
+	}
+	dedupWindow := cfg.TgDedupWindow
+	if dedupWindow == 0 {
+		dedupWindow = tgDefaultDedupWindow
+	}
+
+	c := &telegramClient{
+		endpoint:     fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimSuffix(apiURL, "/"), cfg.TgToken),
+		chatID:       cfg.TgChatID,
+		parseMode:    cfg.TgMsgParseMode,
+		sendInterval: sendInterval,
+		dedupWindow:  dedupWindow,
+		httpClient:   &http.Client{Timeout: tgRequestTimeout},
+		seen:         map[string]time.Time{},
+		suppressed:   map[string]int{},
+	}
+	c.queue = newSendQueue(tgQueueSize, func(msg tgMessage, _ <-chan struct{}) {
+		_ = c.send(msg, true)
+	})
+
+	return c, nil
+}
+
+// allow reports whether a message with the given key may be sent now and
+// how many identical messages were suppressed since the last one.
+func (c *telegramClient) allow(key string, now time.Time) (bool, int) {
+	if c.dedupWindow < 0 {
+		return true, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if last, ok := c.seen[key]; ok && now.Sub(last) < c.dedupWindow {
+		c.suppressed[key]++
+		return false, 0
+	}
+
+	for k, last := range c.seen {
+		if now.Sub(last) >= c.dedupWindow && c.suppressed[k] == 0 {
+			delete(c.seen, k)
+		}
+	}
+
+	repeated := c.suppressed[key]
+	delete(c.suppressed, key)
+	c.seen[key] = now
+
+	return true, repeated
+}
+
+// capture queues the message, or sends it right away when sync is set.
+func (c *telegramClient) capture(msg tgMessage, sync bool) error {
+	ok, repeated := c.allow(msg.key, time.Now())
+	if !ok {
+		return nil
+	}
+	if repeated > 0 {
+		msg.text += "\n\n" + c.escape(fmt.Sprintf("(similar message suppressed %d times)", repeated))
+	}
+
+	if sync {
+		return c.send(msg, false)
+	}
+
+	if !c.queue.enqueue(msg) {
+		return errors.New("telegram queue is full, message dropped")
+	}
+
+	return nil
+}
+
+func (c *telegramClient) send(msg tgMessage, throttle bool) error {
+	c.sendMu.Lock()
+	if throttle {
+		if wait := c.sendInterval - time.Since(c.lastSent); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	c.lastSent = time.Now()
+	c.sendMu.Unlock()
+
+	body, err := json.Marshal(map[string]any{
+		"chat_id":                  c.chatID,
+		"text":                     msg.text,
+		"parse_mode":               c.parseMode,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode telegram message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tgRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create telegram request: %w", withoutURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send telegram message: %w", withoutURL(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("telegram responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// withoutURL drops the request URL, which contains the bot token, from err.
+// The error may be printed by zap to ErrorOutput, which is not redacted.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+
+	return err
+}
+
+func (c *telegramClient) flush(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if !c.queue.flush(ctx) {
+		return errors.New("telegram flush timed out")
+	}
+
+	return nil
+}
+
+// Close sends the queued messages until ctx is done and stops the sending goroutine.
+func (c *telegramClient) Close(ctx context.Context) error {
+	if err := c.queue.close(ctx); err != nil {
+		return fmt.Errorf("telegram: %w", err)
+	}
+
+	return nil
+}
+
+func (c *telegramClient) escape(s string) string {
+	switch c.parseMode {
+	case TgParseModeHTML:
+		return html.EscapeString(s)
+	case TgParseModeMarkdown:
+		return escapeChars(s, "_*`[")
+	case TgParseModeMarkdownV2:
+		return escapeChars(s, "\\_*[]()~`>#+-=|{}.!")
+	default:
+		return s
+	}
+}
+
+func (c *telegramClient) bold(s string) string {
+	switch c.parseMode {
+	case TgParseModeHTML:
+		return "<b>" + html.EscapeString(s) + "</b>"
+	case TgParseModeMarkdown, TgParseModeMarkdownV2:
+		return "*" + c.escape(s) + "*"
+	default:
+		return s
+	}
+}
+
+func escapeChars(s, chars string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+type tgField struct {
+	key   string
+	value string
+}
+
+// tgAlert holds the raw parts of an alert, they are escaped when it is rendered.
+type tgAlert struct {
+	title   string
+	message string
+	err     string
+	fields  []tgField
+	omitted int
+	caller  string
+}
+
+func (c *telegramClient) render(a tgAlert) string {
+	var b strings.Builder
+	b.WriteString(c.bold(a.title))
+	b.WriteString("\n")
+	b.WriteString(c.escape(a.message))
+	if a.err != "" {
+		b.WriteString("\n\n")
+		b.WriteString(c.bold("error:"))
+		b.WriteString(" ")
+		b.WriteString(c.escape(a.err))
+	}
+	if len(a.fields) > 0 || a.omitted > 0 {
+		b.WriteString("\n")
+	}
+	for _, f := range a.fields {
+		b.WriteString("\n")
+		b.WriteString(c.bold(f.key + ":"))
+		b.WriteString(" ")
+		b.WriteString(c.escape(f.value))
+	}
+	if a.omitted > 0 {
+		b.WriteString("\n")
+		b.WriteString(c.escape(fmt.Sprintf("(%d fields omitted)", a.omitted)))
+	}
+	if a.caller != "" {
+		b.WriteString("\n\n")
+		b.WriteString(c.escape(a.caller))
+	}
+
+	return b.String()
+}
+
+// fit renders a within limit runes. The longest fields are dropped first, then the
+// raw message and error are shortened, so escaping and markup are never cut.
+func (c *telegramClient) fit(a tgAlert, limit int) string {
+	text := c.render(a)
+	for utf8.RuneCountInString(text) > limit && len(a.fields) > 0 {
+		longest := 0
+		for i, f := range a.fields {
+			if len(f.key)+len(f.value) > len(a.fields[longest].key)+len(a.fields[longest].value) {
+				longest = i
+			}
+		}
+		a.fields = append(a.fields[:longest:longest], a.fields[longest+1:]...)
+		a.omitted++
+		text = c.render(a)
+	}
+
+	for over := utf8.RuneCountInString(text) - limit; over > 0; over = utf8.RuneCountInString(text) - limit {
+		msgLen, errLen := utf8.RuneCountInString(a.message), utf8.RuneCountInString(a.err)
+		switch {
+		case errLen > 1 && errLen >= msgLen:
+			a.err = truncateRunes(a.err, max(errLen-over, 1))
+		case msgLen > 1:
+			a.message = truncateRunes(a.message, max(msgLen-over, 1))
+		default:
+			return text
+		}
+		text = c.render(a)
+	}
+
+	return text
+}
+
+func truncateRunes(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+
+	return string(runes[:limit-1]) + "…"
+}
+
+// telegramCore is a zapcore.Core forwarding Error and above entries to Telegram.
+type telegramCore struct {
+	client  *telegramClient
+	appName string
+	fields  []zapcore.Field
+}
+
+func newTelegramCore(cfg Config) (*telegramCore, error) {
+	client, err := newTelegramClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &telegramCore{client: client, appName: cfg.TgAppName}, nil
+}
+
+func (c *telegramCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= zapcore.ErrorLevel
+}
+
+func (c *telegramCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+
+	return &clone
+}
+
+func (c *telegramCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *telegramCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	all := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	all = append(all, c.fields...)
+	all = append(all, fields...)
+
+	return c.client.capture(c.format(ent, all), ent.Level > zapcore.ErrorLevel)
+}
+
+func (c *telegramCore) Sync() error {
+	return c.client.flush(tgFlushTimeout)
+}
+
+func (c *telegramCore) format(ent zapcore.Entry, fields []zapcore.Field) tgMessage {
+	var errText string
+	rest := make([]zapcore.Field, 0, len(fields))
+	for _, f := range fields {
+		if f.Type == zapcore.ErrorType {
+			if err, ok := f.Interface.(error); ok && err != nil {
+				errText = err.Error()
+			}
+			continue
+		}
+		rest = append(rest, f)
+	}
+
+	alert := tgAlert{title: ent.Level.CapitalString(), message: ent.Message, err: errText}
+	if c.appName != "" {
+		alert.title = fmt.Sprintf("[%s] %s", c.appName, alert.title)
+	}
+	for k, v := range encodeFields(rest) {
+		alert.fields = append(alert.fields, tgField{key: k, value: fmt.Sprint(v)})
+	}
+	sort.Slice(alert.fields, func(i, j int) bool {
+		return alert.fields[i].key < alert.fields[j].key
+	})
+	if ent.Caller.Defined {
+		alert.caller = ent.Caller.TrimmedPath()
+	}
+
+	return tgMessage{
+		text: c.client.fit(alert, tgMaxMessageLen-tgReservedLen),
+		key:  ent.Level.String() + "\x00" + ent.Message + "\x00" + errText,
+	}
+}
@@ -0,0 +1,252 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// telegramFake records the messages posted to a local Bot API.
+type telegramFake struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	paths    []string
+	messages []map[string]any
+	times    []time.Time
+}
+
+func newTelegramFake(t *testing.T) *telegramFake {
+	t.Helper()
+
+	f := &telegramFake{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.paths = append(f.paths, r.URL.Path)
+		f.messages = append(f.messages, body)
+		f.times = append(f.times, time.Now())
+		f.mu.Unlock()
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *telegramFake) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	texts := make([]string, 0, len(f.messages))
+	for _, m := range f.messages {
+		texts = append(texts, m["text"].(string))
+	}
+
+	return texts
+}
+
+func newTelegramLog(t *testing.T, f *telegramFake, cfg Config) *Log {
+	t.Helper()
+
+	cfg.LogLevel = "info"
+	cfg.OutputPaths = []string{filepath.Join(t.TempDir(), "app.log")}
+	cfg.TgToken = "123:abc"
+	cfg.TgChatID = 42
+	cfg.TgAPIURL = f.URL + "/"
+	if cfg.TgSendInterval == 0 {
+		cfg.TgSendInterval = time.Millisecond
+	}
+	l := New(cfg)
+	if l == nil {
+		t.Fatal("New returned nil")
+	}
+
+	return l
+}
+
+func TestTelegramEscape(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: TgParseModeHTML, want: "a &lt;b&gt; &amp; *c_d* [e](f) 1.5!"},
+		{mode: TgParseModeMarkdown, want: "a <b> & \\*c\\_d\\* \\[e](f) 1.5!"},
+		{mode: TgParseModeMarkdownV2, want: "a <b\\> & \\*c\\_d\\* \\[e\\]\\(f\\) 1\\.5\\!"},
+		{mode: "", want: "a <b> & *c_d* [e](f) 1.5!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			c := &telegramClient{parseMode: tt.mode}
+			if got := c.escape("a <b> & *c_d* [e](f) 1.5!"); got != tt.want {
+				t.Errorf("escape = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTelegramSend(t *testing.T) {
+	f := newTelegramFake(t)
+	l := newTelegramLog(t, f, Config{TgMsgParseMode: TgParseModeHTML, TgAppName: "shop"})
+
+	l.Info("not an alert")
+	l.WithErr(errors.New("a < b")).Errorw("charge failed", "order", "7&8")
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := f.texts()
+	if len(texts) != 1 {
+		t.Fatalf("sent %d messages, want 1: %q", len(texts), texts)
+	}
+	if f.paths[0] != "/bot123:abc/sendMessage" {
+		t.Errorf("posted to %q", f.paths[0])
+	}
+	if f.messages[0]["chat_id"] != float64(42) || f.messages[0]["parse_mode"] != TgParseModeHTML {
+		t.Errorf("message = %v", f.messages[0])
+	}
+	for _, want := range []string{"<b>[shop] ERROR</b>\ncharge failed", "<b>error:</b> a &lt; b", "<b>order:</b> 7&amp;8"} {
+		if !strings.Contains(texts[0], want) {
+			t.Errorf("text %q does not contain %q", texts[0], want)
+		}
+	}
+}
+
+func TestTelegramDedup(t *testing.T) {
+	f := newTelegramFake(t)
+	l := newTelegramLog(t, f, Config{})
+
+	for i := 0; i < 3; i++ {
+		l.WithErr(errors.New("timeout")).Error("payment failed")
+	}
+	l.WithErr(errors.New("refused")).Error("payment failed")
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := f.texts(); len(texts) != 2 {
+		t.Fatalf("sent %d messages, want 2: %q", len(texts), texts)
+	}
+}
+
+func TestTelegramAllowReportsSuppressed(t *testing.T) {
+	c := &telegramClient{dedupWindow: time.Minute, seen: map[string]time.Time{}, suppressed: map[string]int{}}
+	start := time.Now()
+
+	steps := []struct {
+		after    time.Duration
+		allowed  bool
+		repeated int
+	}{
+		{after: 0, allowed: true},
+		{after: time.Second, allowed: false},
+		{after: 2 * time.Second, allowed: false},
+		{after: time.Minute + time.Second, allowed: true, repeated: 2},
+		{after: time.Minute + 2*time.Second, allowed: false},
+	}
+	for i, step := range steps {
+		allowed, repeated := c.allow("key", start.Add(step.after))
+		if allowed != step.allowed || repeated != step.repeated {
+			t.Errorf("step %d: allow = %v, %d, want %v, %d", i, allowed, repeated, step.allowed, step.repeated)
+		}
+	}
+}
+
+func TestTelegramSendInterval(t *testing.T) {
+	f := newTelegramFake(t)
+	interval := 50 * time.Millisecond
+	l := newTelegramLog(t, f, Config{TgSendInterval: interval})
+
+	for _, msg := range []string{"a", "b", "c"} {
+		l.Error(msg)
+	}
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.times) != 3 {
+		t.Fatalf("sent %d messages, want 3", len(f.times))
+	}
+	for i := 1; i < len(f.times); i++ {
+		// Allow for the request time counted from the previous send.
+		if gap := f.times[i].Sub(f.times[i-1]); gap < interval-10*time.Millisecond {
+			t.Errorf("messages %d and %d were sent %v apart, want at least %v", i-1, i, gap, interval)
+		}
+	}
+}
+
+var htmlEntity = regexp.MustCompile(`&(lt|gt|amp|quot|#39);`)
+
+func TestTelegramLongMessage(t *testing.T) {
+	f := newTelegramFake(t)
+	l := newTelegramLog(t, f, Config{TgMsgParseMode: TgParseModeHTML, TgDedupWindow: -1})
+
+	stack := strings.Repeat("main.(*T).f<int>\n\tmain.go:1 & more\n", 400)
+	l.Errorw("stack", ErrorStackField, stack, "user", "bob")
+	l.WithErr(errors.New(strings.Repeat("<&>", 3000))).Error(strings.Repeat("m&", 3000))
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := f.texts()
+	if len(texts) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(texts))
+	}
+	for i, text := range texts {
+		if n := utf8.RuneCountInString(text); n > tgMaxMessageLen {
+			t.Errorf("message %d has %d runes", i, n)
+		}
+		if strings.Count(text, "<b>") != strings.Count(text, "</b>") {
+			t.Errorf("message %d has unbalanced tags: %q", i, text)
+		}
+		rest := htmlEntity.ReplaceAllString(text, "")
+		if strings.Contains(rest, "&") {
+			t.Errorf("message %d has a cut entity", i)
+		}
+	}
+	if !strings.Contains(texts[0], "<b>user:</b> bob") || !strings.Contains(texts[0], "(1 fields omitted)") {
+		t.Errorf("the stack should be dropped as a whole field: %q", texts[0])
+	}
+}
+
+func TestTelegramErrorsHideToken(t *testing.T) {
+	const token = "123:s3cr3t"
+
+	// A closed server refuses the connection, a bad URL fails the request.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	for _, apiURL := range []string{closed.URL, "http://bad host"} {
+		core, err := newTelegramCore(Config{TgToken: token, TgChatID: 42, TgAPIURL: apiURL})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var errOut bytes.Buffer
+		logger := zap.New(core, zap.ErrorOutput(zapcore.AddSync(&errOut)))
+		logger.DPanic("failed")
+
+		if !strings.Contains(errOut.String(), "failed to ") {
+			t.Errorf("%s: send error was not reported: %q", apiURL, errOut.String())
+		}
+		if strings.Contains(errOut.String(), token) {
+			t.Errorf("%s: error output contains the token: %q", apiURL, errOut.String())
+		}
+	}
+}
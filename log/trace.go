@@ -0,0 +1,118 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	TraceIDField      = "trace_id"
+	SpanIDField       = "span_id"
+	TraceSampledField = "trace_sampled"
+)
+
+// traceFields returns the ids of the span in ctx, if any.
+func traceFields(ctx context.Context) Fld {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+
+	return Fld{
+		TraceIDField:      sc.TraceID().String(),
+		SpanIDField:       sc.SpanID().String(),
+		TraceSampledField: sc.IsSampled(),
+	}
+}
+
+// withSpan returns a zap option mirroring Error and above entries to span.
+func withSpan(span trace.Span, setStatus bool, redactor *redactor) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		if sc, ok := core.(*spanCore); ok {
+			return &spanCore{Core: sc.Core, span: span, setStatus: setStatus, redactor: redactor, fields: sc.fields}
+		}
+
+		return &spanCore{Core: core, span: span, setStatus: setStatus, redactor: redactor}
+	})
+}
+
+// spanCore passes entries to the wrapped core and records Error and above
+// entries as events on a recording span.
+type spanCore struct {
+	zapcore.Core
+	span      trace.Span
+	setStatus bool
+	redactor  *redactor
+	fields    []zapcore.Field
+}
+
+func (c *spanCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+
+	return &clone
+}
+
+func (c *spanCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	ce = c.Core.Check(ent, ce)
+	if ent.Level >= zapcore.ErrorLevel && c.span.IsRecording() {
+		ce = ce.AddCore(ent, spanWriter{c})
+	}
+
+	return ce
+}
+
+// spanWriter is the part of spanCore registered on a CheckedEntry,
+// so that Write does not reach the wrapped core twice.
+type spanWriter struct {
+	*spanCore
+}
+
+func (w spanWriter) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	all := make([]zapcore.Field, 0, len(w.fields)+len(fields))
+	all = append(all, w.fields...)
+	all = append(all, fields...)
+	if w.redactor != nil {
+		ent.Message = w.redactor.String(ent.Message)
+		all = w.redactor.Fields(all)
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("log.severity", ent.Level.CapitalString()),
+		attribute.String("log.message", ent.Message),
+	}
+	var spanErr error
+	for _, f := range all {
+		if f.Type == zapcore.ErrorType {
+			if err, ok := f.Interface.(error); ok {
+				spanErr = err
+			}
+		}
+	}
+	for k, v := range encodeFields(all) {
+		attrs = append(attrs, attribute.String(k, fmt.Sprint(v)))
+	}
+
+	if spanErr != nil {
+		w.span.RecordError(spanErr, trace.WithAttributes(attrs...))
+	} else {
+		w.span.AddEvent("log", trace.WithAttributes(attrs...))
+	}
+	if w.setStatus {
+		w.span.SetStatus(codes.Error, ent.Message)
+	}
+
+	return nil
+}
+
+func (w spanWriter) Sync() error {
+	return nil
+}
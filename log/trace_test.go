@@ -0,0 +1,143 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"testing"
+)
+
+func lastEntry(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	var entry map[string]any
+	if err := json.Unmarshal(lines[len(lines)-1], &entry); err != nil {
+		t.Fatalf("%v: %s", err, buf)
+	}
+
+	return entry
+}
+
+func TestWithCtxTraceFields(t *testing.T) {
+	l, buf := newBufferLog(t, Config{})
+
+	for _, sampled := range []bool{true, false} {
+		sampler := sdktrace.NeverSample()
+		if sampled {
+			sampler = sdktrace.AlwaysSample()
+		}
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler))
+		ctx, span := tp.Tracer("test").Start(context.Background(), "op")
+
+		l.WithCtx(ctx).Info("traced")
+		span.End()
+
+		entry := lastEntry(t, buf)
+		sc := span.SpanContext()
+		if entry[TraceIDField] != sc.TraceID().String() || entry[SpanIDField] != sc.SpanID().String() {
+			t.Errorf("sampled %v: entry = %v, want ids of %v", sampled, entry, sc)
+		}
+		if entry[TraceSampledField] != sampled {
+			t.Errorf("%s = %v, want %v", TraceSampledField, entry[TraceSampledField], sampled)
+		}
+	}
+}
+
+func TestWithCtxWithoutSpan(t *testing.T) {
+	l, buf := newBufferLog(t, Config{TraceErrorEvents: true, TraceSetErrorStatus: true})
+
+	// A no-op provider creates spans with an invalid context that are never recorded.
+	ctx, span := noop.NewTracerProvider().Tracer("test").Start(context.Background(), "op")
+	l.WithCtx(ctx).Error("no span")
+	span.End()
+	l.WithCtx(context.Background()).Error("no context")
+
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		for _, key := range []string{TraceIDField, SpanIDField, TraceSampledField} {
+			if bytes.Contains(line, []byte(key)) {
+				t.Errorf("%s logged without a valid span: %s", key, line)
+			}
+		}
+	}
+}
+
+func TestErrorSpanEvents(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	l, buf := newBufferLog(t, Config{TraceErrorEvents: true, TraceSetErrorStatus: true})
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "op")
+	logger := l.WithCtx(ctx).With(Fld{"order_id": 7})
+	logger.Info("not an event")
+	logger.Warn("not an event either")
+	logger.WithErr(errors.New("connection refused")).Errorw("charge failed", "password", "hunter2")
+	logger.Error("retry failed")
+	span.End()
+
+	if !bytes.Contains(buf.Bytes(), []byte("charge failed")) {
+		t.Errorf("entries must still reach the outputs: %s", buf)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	events := spans[0].Events()
+	if len(events) != 2 {
+		t.Fatalf("recorded %d events, want 2: %+v", len(events), events)
+	}
+
+	exception := attrMap(events[0].Attributes)
+	if events[0].Name != "exception" || exception["exception.message"] != "connection refused" {
+		t.Errorf("first event = %s %v, want the error recorded", events[0].Name, exception)
+	}
+	for key, want := range map[string]string{
+		"log.severity": "ERROR",
+		"log.message":  "charge failed",
+		"order_id":     "7",
+		"password":     DefaultRedactMask,
+	} {
+		if exception[key] != want {
+			t.Errorf("event attribute %s = %q, want %q", key, exception[key], want)
+		}
+	}
+
+	if events[1].Name != "log" || attrMap(events[1].Attributes)["log.message"] != "retry failed" {
+		t.Errorf("second event = %s %v", events[1].Name, attrMap(events[1].Attributes))
+	}
+
+	if status := spans[0].Status(); status.Code != codes.Error || status.Description != "retry failed" {
+		t.Errorf("status = %+v, want the last error", status)
+	}
+}
+
+func TestErrorSpanEventsDisabled(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	l, _ := newBufferLog(t, Config{})
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "op")
+	l.WithCtx(ctx).Error("failed")
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || len(spans[0].Events()) != 0 || spans[0].Status().Code != codes.Unset {
+		t.Errorf("span was changed with TraceErrorEvents off: %+v", spans)
+	}
+}
+
+func attrMap(attrs []attribute.KeyValue) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, kv := range attrs {
+		m[string(kv.Key)] = kv.Value.Emit()
+	}
+
+	return m
+}
@@ -17,3 +17,11 @@ func (c *Config) ConnectionString() string {
 		c.Host, c.User, c.Password, c.Database, c.Port,
 	)
 }
+
+// Redact returns a copy of the config without the password, so it can be logged safely.
+func (c Config) Redact() any {
+	if c.Password != "" {
+		c.Password = "[REDACTED]"
+	}
+	return c
+}
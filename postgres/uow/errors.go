@@ -1,13 +1,13 @@
 package uow
 
-import "errors"
+import "github.com/D1sordxr/packages/log"
 
 var (
-	ErrTxStartFailed = errors.New("failed to start transaction")
-	ErrNoCommitTx    = errors.New("no transaction to commit")
-	ErrCommitTx      = errors.New("failed to commit transaction")
-	ErrNoRollbackTx  = errors.New("no transaction to rollback")
-	ErrRollbackTx    = errors.New("failed to rollback tx")
-	ErrExecBatch     = errors.New("error while executing batch")
-	ErrClosingBatch  = errors.New("error while closing batch")
+	ErrTxStartFailed = log.NewError(log.CodeUnavailable, "failed to start transaction")
+	ErrNoCommitTx    = log.NewError(log.CodeInternal, "no transaction to commit")
+	ErrCommitTx      = log.NewError(log.CodeUnavailable, "failed to commit transaction")
+	ErrNoRollbackTx  = log.NewError(log.CodeInternal, "no transaction to rollback")
+	ErrRollbackTx    = log.NewError(log.CodeUnavailable, "failed to rollback tx")
+	ErrExecBatch     = log.NewError(log.CodeInternal, "error while executing batch")
+	ErrClosingBatch  = log.NewError(log.CodeInternal, "error while closing batch")
 )